@@ -0,0 +1,106 @@
+package fwish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rez-go/fwish/version"
+)
+
+// BaselineDescriptionDefault is the description used by Baseline when
+// none was provided. It's the same as Flyway's.
+const BaselineDescriptionDefault = "<< Flyway Baseline >>"
+
+// ErrSchemaAlreadyMigrated is returned by Baseline when the metadata
+// table already contains migrations.
+var ErrSchemaAlreadyMigrated = errors.New("fwish: schema already has applied migrations")
+
+// Baseline marks an existing schema, which was created without fwish,
+// as being at the specified version. Only the migrations with version
+// higher than the baseline version will be applied by Migrate.
+//
+// The metadata table will be created if it doesn't exist. The baseline
+// is recorded as a BASELINE row, like Flyway does. It returns
+// ErrSchemaAlreadyMigrated if the schema has any migration recorded.
+//
+// The schemaName parameter has the same semantic as Migrate's.
+func (m *Migrator) Baseline(db DB, schemaName string, versionStr, description string) error {
+	return m.BaselineContext(context.Background(), contextDB(db), schemaName, versionStr, description)
+}
+
+// BaselineContext is the context-aware variant of Baseline.
+func (m *Migrator) BaselineContext(
+	ctx context.Context, db ContextDB, schemaName string, versionStr, description string,
+) error {
+	vints, err := version.Parse(versionStr)
+	if err != nil {
+		return err
+	}
+	if vints == nil {
+		return errors.New("fwish: baseline version is required")
+	}
+	if description == "" {
+		description = BaselineDescriptionDefault
+	}
+
+	st, err := m.newState(db, schemaName)
+	if err != nil {
+		return err
+	}
+
+	releaseConn, err := m.pinConn(ctx, st)
+	if err != nil {
+		return err
+	}
+	defer releaseConn()
+
+	releaseLock, err := m.acquireLock(ctx, st)
+	if err != nil {
+		return err
+	}
+	defer releaseLock()
+
+	rows, err := readHistory(ctx, st)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if row.installedRank != 0 && row.typ != MigrationTypeSchema {
+			return ErrSchemaAlreadyMigrated
+		}
+	}
+
+	err = m.ensureDBSchemaInitialized(ctx, st)
+	if err != nil {
+		return err
+	}
+
+	_, err = st.db.ExecContext(ctx,
+		fmt.Sprintf(
+			`INSERT INTO %s (
+				installed_rank,
+				version,
+				description,
+				type,
+				script,
+				checksum,
+				installed_by,
+				installed_on,
+				execution_time,
+				success )
+			VALUES ($1,$2,$3,$4,$5,NULL,$6,$7,0,true)`,
+			st.quotedMetatable(),
+		),
+		st.installedRank+1, vints.String(), description, MigrationTypeBaseline,
+		description, m.userID, time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	m.logf("Baselined schema %q at version %s", st.schemaName, vints.String())
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package fwish
+
+import (
+	"context"
+	"fmt"
+)
+
+// CallbackEvent is a point in the lifecycle of Migrate where callbacks
+// are executed. The values are the same as Flyway's so that the SQL
+// callbacks are named the same, e.g., beforeMigrate.sql.
+type CallbackEvent string
+
+const (
+	// CallbackBeforeMigrate is after the schema has been validated and
+	// initialized, before the pending migrations are executed.
+	CallbackBeforeMigrate CallbackEvent = "beforeMigrate"
+	// CallbackBeforeEachMigrate is before each migration is executed,
+	// within the migration's transaction.
+	CallbackBeforeEachMigrate CallbackEvent = "beforeEachMigrate"
+	// CallbackAfterEachMigrate is after each migration is executed
+	// successfully, within the migration's transaction.
+	CallbackAfterEachMigrate CallbackEvent = "afterEachMigrate"
+	// CallbackAfterEachMigrateError is after a migration failed, after
+	// its transaction has been rolled back.
+	CallbackAfterEachMigrateError CallbackEvent = "afterEachMigrateError"
+	// CallbackAfterMigrate is after all the pending migrations have
+	// been executed successfully.
+	CallbackAfterMigrate CallbackEvent = "afterMigrate"
+	// CallbackAfterMigrateError is after Migrate failed, including when
+	// an afterMigrate callback failed.
+	CallbackAfterMigrateError CallbackEvent = "afterMigrateError"
+)
+
+// CallbackEvents lists all the events in the order they might occur.
+var CallbackEvents = []CallbackEvent{
+	CallbackBeforeMigrate,
+	CallbackBeforeEachMigrate,
+	CallbackAfterEachMigrate,
+	CallbackAfterEachMigrateError,
+	CallbackAfterMigrate,
+	CallbackAfterMigrateError,
+}
+
+// CallbackInfo holds the details of the event a callback is executed
+// for.
+type CallbackInfo struct {
+	Event      CallbackEvent
+	SchemaName string
+	// Migration is the migration being executed, or the one which
+	// failed, for the *EachMigrate* events. It's nil for the other
+	// events.
+	Migration *MigrationInfo
+	// NumApplied is the number of migrations applied, for the
+	// afterMigrate event.
+	NumApplied int
+	// Err is the error for the error events.
+	Err error
+	// Placeholders holds the values for the placeholders in the
+	// callback scripts. See MigrationInfo.
+	Placeholders map[string]string
+}
+
+// Callback is a Go callback. The db is the transaction of the migration
+// for the beforeEachMigrate and afterEachMigrate events, unless the
+// migration is non-transactional. Returning an error fails the
+// migration, except for the error events where it's only logged.
+type Callback func(ctx context.Context, db Executor, info CallbackInfo) error
+
+// CallbackSource is an optional interface for migration sources which
+// provide callbacks, e.g., SQL callback scripts. A source's callbacks
+// are executed before the Go callbacks.
+type CallbackSource interface {
+	MigrationSource
+	ExecuteCallback(ctx context.Context, db Executor, info CallbackInfo) error
+}
+
+// OnBeforeMigrate registers a callback for CallbackBeforeMigrate.
+func (m *Migrator) OnBeforeMigrate(cb Callback) *Migrator {
+	return m.addCallback(CallbackBeforeMigrate, cb)
+}
+
+// OnBeforeEach registers a callback for CallbackBeforeEachMigrate.
+func (m *Migrator) OnBeforeEach(cb Callback) *Migrator {
+	return m.addCallback(CallbackBeforeEachMigrate, cb)
+}
+
+// OnAfterEach registers a callback for CallbackAfterEachMigrate.
+func (m *Migrator) OnAfterEach(cb Callback) *Migrator {
+	return m.addCallback(CallbackAfterEachMigrate, cb)
+}
+
+// OnAfterMigrate registers a callback for CallbackAfterMigrate.
+func (m *Migrator) OnAfterMigrate(cb Callback) *Migrator {
+	return m.addCallback(CallbackAfterMigrate, cb)
+}
+
+// OnError registers a callback for CallbackAfterEachMigrateError and
+// CallbackAfterMigrateError. The event could be told from the info.
+func (m *Migrator) OnError(cb Callback) *Migrator {
+	m.addCallback(CallbackAfterEachMigrateError, cb)
+	return m.addCallback(CallbackAfterMigrateError, cb)
+}
+
+func (m *Migrator) addCallback(event CallbackEvent, cb Callback) *Migrator {
+	if m.callbacks == nil {
+		m.callbacks = map[CallbackEvent][]Callback{}
+	}
+	m.callbacks[event] = append(m.callbacks[event], cb)
+	return m
+}
+
+// runCallbacks executes the callbacks of the sources then the Go
+// callbacks for the event. sf is the migration for the *EachMigrate
+// events.
+func (m *Migrator) runCallbacks(
+	ctx context.Context, db Executor, st *state, event CallbackEvent, sf *migration, info CallbackInfo,
+) error {
+	info = m.callbackInfo(st, event, sf, info)
+
+	for _, src := range m.sources {
+		cs, ok := src.(CallbackSource)
+		if !ok {
+			continue
+		}
+		if err := cs.ExecuteCallback(ctx, db, info); err != nil {
+			return fmt.Errorf("fwish: %s callback failed: %w", event, err)
+		}
+	}
+	for _, cb := range m.callbacks[event] {
+		if err := cb(ctx, db, info); err != nil {
+			return fmt.Errorf("fwish: %s callback failed: %w", event, err)
+		}
+	}
+	return nil
+}
+
+// callbackInfo fills the details of the event in info.
+func (m *Migrator) callbackInfo(st *state, event CallbackEvent, sf *migration, info CallbackInfo) CallbackInfo {
+	info.Event = event
+	info.SchemaName = st.schemaName
+	info.Placeholders = m.migrationPlaceholders(st, sf)
+	if sf != nil {
+		mi := sf.info()
+		mi.Placeholders = info.Placeholders
+		info.Migration = &mi
+	}
+	return info
+}
+
+// runErrorCallbacks executes the callbacks for an error event. They are
+// executed even if the context has been cancelled. Their errors are
+// logged as the original error takes precedence.
+func (m *Migrator) runErrorCallbacks(
+	ctx context.Context, st *state, event CallbackEvent, sf *migration, err error,
+) {
+	cbErr := m.runCallbacks(context.WithoutCancel(ctx), st.db, st, event, sf, CallbackInfo{Err: err})
+	if cbErr != nil {
+		m.logf("Callback for schema %q returned error: %v", st.schemaName, cbErr)
+	}
+}
@@ -0,0 +1,158 @@
+package fwish
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// ErrCleanDisabled is returned by Clean when it has not been enabled
+// with WithAllowClean.
+var ErrCleanDisabled = errors.New("fwish: clean is disabled")
+
+// WithAllowClean sets whether Clean is allowed to be executed. It's
+// disabled by default as it's destructive; it should only be enabled
+// for development and test environments.
+func (m *Migrator) WithAllowClean(allow bool) *Migrator {
+	m.allowClean = allow
+	return m
+}
+
+// Clean drops all the objects, i.e., tables, views, sequences, functions
+// and types, in the schema including the metadata table. The schema
+// itself is kept so that its grants are preserved. Objects which belong
+// to extensions are not dropped.
+//
+// It returns ErrCleanDisabled unless it has been enabled with
+// WithAllowClean, and ErrSchemaIDMismatch if the schema belongs to
+// another migrator.
+//
+// The schemaName parameter has the same semantic as Migrate's.
+func (m *Migrator) Clean(db DB, schemaName string) error {
+	return m.CleanContext(context.Background(), contextDB(db), schemaName)
+}
+
+// CleanContext is the context-aware variant of Clean.
+func (m *Migrator) CleanContext(ctx context.Context, db ContextDB, schemaName string) error {
+	if !m.allowClean {
+		return ErrCleanDisabled
+	}
+
+	st, err := m.newState(db, schemaName)
+	if err != nil {
+		return err
+	}
+
+	releaseConn, err := m.pinConn(ctx, st)
+	if err != nil {
+		return err
+	}
+	defer releaseConn()
+
+	releaseLock, err := m.acquireLock(ctx, st)
+	if err != nil {
+		return err
+	}
+	defer releaseLock()
+
+	rows, err := readHistory(ctx, st)
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 && rows[0].installedRank == 0 &&
+		m.schemaID != "" && rows[0].script != m.schemaID {
+		return ErrSchemaIDMismatch
+	}
+
+	var numDropped int
+	err = doTx(ctx, st.db, func(tx *sql.Tx) error {
+		// The order matters. Dependents are dropped before the objects
+		// they depend on, though CASCADE would take care of the rest.
+		for _, q := range cleanObjectQueries {
+			stmts, err := queryStrings(ctx, tx, q, st.schemaName)
+			if err != nil {
+				return err
+			}
+			for _, stmt := range stmts {
+				if _, err = tx.ExecContext(ctx, stmt); err != nil {
+					return err
+				}
+				numDropped++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logf("Cleaned schema %q (%d objects dropped)", st.schemaName, numDropped)
+
+	return nil
+}
+
+// Each query returns the DROP statements for a class of objects in the
+// schema specified by the first parameter.
+var cleanObjectQueries = []string{
+	// Materialized views, views, tables, foreign tables and sequences
+	`SELECT 'DROP ' || CASE c.relkind
+			WHEN 'm' THEN 'MATERIALIZED VIEW'
+			WHEN 'v' THEN 'VIEW'
+			WHEN 'f' THEN 'FOREIGN TABLE'
+			WHEN 'S' THEN 'SEQUENCE'
+			ELSE 'TABLE' END
+		|| ' IF EXISTS ' || quote_ident(n.nspname) || '.' || quote_ident(c.relname)
+		|| ' CASCADE'
+	FROM pg_catalog.pg_class c
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = $1 AND c.relkind IN ('m', 'v', 'r', 'p', 'f', 'S')
+		AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend d
+			WHERE d.objid = c.oid AND d.deptype = 'e')
+	ORDER BY CASE c.relkind
+		WHEN 'm' THEN 0 WHEN 'v' THEN 1 WHEN 'S' THEN 3 ELSE 2 END,
+		c.relname`,
+	// Functions, procedures and aggregates
+	`SELECT 'DROP ' || CASE p.prokind
+			WHEN 'a' THEN 'AGGREGATE'
+			WHEN 'p' THEN 'PROCEDURE'
+			ELSE 'FUNCTION' END
+		|| ' IF EXISTS ' || quote_ident(n.nspname) || '.' || quote_ident(p.proname)
+		|| '(' || pg_catalog.pg_get_function_identity_arguments(p.oid) || ') CASCADE'
+	FROM pg_catalog.pg_proc p
+		JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+	WHERE n.nspname = $1
+		AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend d
+			WHERE d.objid = p.oid AND d.deptype = 'e')
+	ORDER BY p.proname`,
+	// Domains, enums, ranges and standalone composite types
+	`SELECT 'DROP ' || CASE t.typtype WHEN 'd' THEN 'DOMAIN' ELSE 'TYPE' END
+		|| ' IF EXISTS ' || quote_ident(n.nspname) || '.' || quote_ident(t.typname)
+		|| ' CASCADE'
+	FROM pg_catalog.pg_type t
+		JOIN pg_catalog.pg_namespace n ON n.oid = t.typnamespace
+	WHERE n.nspname = $1
+		AND (t.typtype IN ('d', 'e', 'r') OR (t.typtype = 'c' AND EXISTS (
+			SELECT 1 FROM pg_catalog.pg_class c
+			WHERE c.oid = t.typrelid AND c.relkind = 'c')))
+		AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend d
+			WHERE d.objid = t.oid AND d.deptype = 'e')
+	ORDER BY CASE t.typtype WHEN 'd' THEN 0 ELSE 1 END, t.typname`,
+}
+
+func queryStrings(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sl []string
+	for rows.Next() {
+		var s string
+		if err = rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		sl = append(sl, s)
+	}
+	return sl, rows.Err()
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var baselineCmd = &cobra.Command{
+	Use:   "baseline",
+	Short: "Baseline an existing schema at the specified version",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.New(os.Stderr, "", log.LstdFlags)
+
+		if baselineVersion == "" {
+			logger.Fatal("Baseline version is required")
+		}
+
+		mg, src, db := setupMigrator(logger)
+
+		err := mg.BaselineContext(cmd.Context(), db, "", baselineVersion, baselineDescription)
+		if err != nil {
+			fatal(logger, err)
+		}
+
+		logger.Printf("Successfully baselined schema %q at version %s.",
+			src.SchemaName(), baselineVersion)
+	},
+}
+
+var (
+	baselineVersion     string
+	baselineDescription string
+)
+
+func init() {
+	baselineCmd.Flags().StringVarP(&baselineVersion, "version", "", "", "Version to tag the existing schema with")
+	baselineCmd.Flags().StringVarP(&baselineDescription, "description", "", "", "Description of the baseline")
+
+	rootCmd.AddCommand(baselineCmd)
+}
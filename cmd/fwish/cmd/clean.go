@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var cleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Drop all objects in the schema",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.New(os.Stderr, "", log.LstdFlags)
+
+		if !cleanAllow {
+			logger.Fatal("Clean is disabled. Use --allow-clean to enable it.")
+		}
+
+		mg, src, db := setupMigrator(logger)
+		mg.WithAllowClean(true)
+
+		err := mg.CleanContext(cmd.Context(), db, "")
+		if err != nil {
+			fatal(logger, err)
+		}
+
+		logger.Printf("Successfully cleaned schema %q.", src.SchemaName())
+	},
+}
+
+var cleanAllow bool
+
+func init() {
+	cleanCmd.Flags().BoolVarP(&cleanAllow, "allow-clean", "", false, "Allow dropping all objects in the schema")
+
+	rootCmd.AddCommand(cleanCmd)
+}
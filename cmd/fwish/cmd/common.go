@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rez-go/fwish"
+	sqlsource "github.com/rez-go/fwish/sources/sql"
+)
+
+// setupMigrator loads the source pointed by the --source flag and
+// connects to the database pointed by the --db flag. It terminates the
+// program if any of those failed.
+func setupMigrator(logger *log.Logger) (*fwish.Migrator, fwish.MigrationSource, *sql.DB) {
+	if sourceDir == "" {
+		fmt.Fprintf(os.Stderr, "Source directory is required\n")
+		os.Exit(1)
+	}
+	if dbURL == "" {
+		fmt.Fprintf(os.Stderr, "Database connection string is required\n")
+		os.Exit(1)
+	}
+
+	parsedURL, err := url.Parse(dbURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to parse database connection string\n")
+		os.Exit(1)
+	}
+	username := parsedURL.User.Username()
+
+	mg, err := fwish.NewMigrator("")
+	if err != nil {
+		panic(err)
+	}
+	mg.WithLogger(logger)
+	mg.WithUserID(username)
+	mg.WithOutOfOrder(outOfOrder)
+	mg.WithLockTimeout(lockTimeout)
+	mg.WithFlywayMode(flywayMode)
+	mg.WithMetatableName(tableName)
+	if len(placeholders) > 0 {
+		values := map[string]string{}
+		for _, ph := range placeholders {
+			k, v, ok := strings.Cut(ph, "=")
+			if !ok || k == "" {
+				fmt.Fprintf(os.Stderr, "Invalid placeholder %q, expecting key=value\n", ph)
+				os.Exit(1)
+			}
+			values[k] = v
+		}
+		mg.WithPlaceholders(values)
+	}
+	src, err := sqlsource.LoadDir(sourceDir)
+	if err != nil {
+		if err == fwish.ErrSchemaIndexFileNotFound {
+			logger.Fatal("Source does not contain fwish.yaml file")
+		}
+		panic(err)
+	}
+	err = mg.AddSource(src)
+	if err != nil {
+		panic(err)
+	}
+
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	return mg, src, db
+}
+
+// confirm asks the user a yes/no question through the terminal.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// The exit codes for the errors which callers, e.g., deployment
+// scripts, might want to react to.
+const (
+	exitCodeError            = 1
+	exitCodeChecksumMismatch = 2
+	exitCodeMissingMigration = 3
+	exitCodeFailedMigration  = 4
+	exitCodeUnknownMigration = 5
+	exitCodeIgnoredMigration = 6
+	exitCodeSchemaIDMismatch = 7
+)
+
+// exitCode returns the exit code for the error.
+func exitCode(err error) int {
+	var (
+		checksumErr *fwish.ChecksumMismatchError
+		missingErr  *fwish.MissingMigrationError
+		failedErr   *fwish.FailedMigrationError
+		unknownErr  *fwish.UnknownAppliedMigrationError
+		ignoredErr  *fwish.IgnoredMigrationError
+	)
+	switch {
+	case errors.As(err, &checksumErr):
+		return exitCodeChecksumMismatch
+	case errors.As(err, &missingErr):
+		return exitCodeMissingMigration
+	case errors.As(err, &failedErr):
+		return exitCodeFailedMigration
+	case errors.As(err, &unknownErr):
+		return exitCodeUnknownMigration
+	case errors.As(err, &ignoredErr):
+		return exitCodeIgnoredMigration
+	case errors.Is(err, fwish.ErrSchemaIDMismatch):
+		return exitCodeSchemaIDMismatch
+	}
+	return exitCodeError
+}
+
+// fatal writes the error to the logger and terminates the program with
+// the exit code for the error.
+func fatal(logger *log.Logger, err error) {
+	logger.Print(err)
+	os.Exit(exitCode(err))
+}
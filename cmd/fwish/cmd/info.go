@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rez-go/fwish"
+)
+
+var infoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Print the details and status of all migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.New(os.Stderr, "", log.LstdFlags)
+
+		switch infoOutputFormat {
+		case outputFormatTable, outputFormatJSON, outputFormatYAML:
+		default:
+			logger.Fatalf("Unsupported output format %q", infoOutputFormat)
+		}
+
+		mg, _, db := setupMigrator(logger)
+
+		status, err := mg.StatusContext(cmd.Context(), db, "")
+		if err != nil {
+			fatal(logger, err)
+		}
+
+		if infoOutputFormat == outputFormatTable {
+			err = writeInfoTable(status)
+		} else {
+			err = writeStructured(os.Stdout, infoOutputFormat, infoFromStatus(status))
+		}
+		if err != nil {
+			fatal(logger, err)
+		}
+	},
+}
+
+var infoOutputFormat string
+
+func init() {
+	infoCmd.Flags().StringVarP(&infoOutputFormat, "output", "o", outputFormatTable, "Output format: table, json or yaml")
+
+	rootCmd.AddCommand(infoCmd)
+}
+
+// infoOutput is the machine-readable representation of fwish.Status.
+type infoOutput struct {
+	SchemaID       string          `json:"schema_id" yaml:"schema_id"`
+	SchemaName     string          `json:"schema_name" yaml:"schema_name"`
+	Initialized    bool            `json:"initialized" yaml:"initialized"`
+	CurrentVersion string          `json:"current_version" yaml:"current_version"`
+	Migrations     []infoMigration `json:"migrations" yaml:"migrations"`
+}
+
+type infoMigration struct {
+	InstalledRank int32      `json:"installed_rank,omitempty" yaml:"installed_rank,omitempty"`
+	Version       string     `json:"version" yaml:"version"`
+	Description   string     `json:"description" yaml:"description"`
+	Type          string     `json:"type" yaml:"type"`
+	Script        string     `json:"script" yaml:"script"`
+	Repeatable    bool       `json:"repeatable" yaml:"repeatable"`
+	Checksum      int32      `json:"checksum" yaml:"checksum"`
+	InstalledBy   string     `json:"installed_by,omitempty" yaml:"installed_by,omitempty"`
+	InstalledOn   *time.Time `json:"installed_on,omitempty" yaml:"installed_on,omitempty"`
+	ExecutionTime int64      `json:"execution_time_ms" yaml:"execution_time_ms"`
+	State         string     `json:"state" yaml:"state"`
+}
+
+func infoFromStatus(status *fwish.Status) infoOutput {
+	out := infoOutput{
+		SchemaID:       status.SchemaID,
+		SchemaName:     status.SchemaName,
+		Initialized:    status.Initialized,
+		CurrentVersion: status.CurrentVersion,
+		Migrations:     []infoMigration{},
+	}
+	for _, ms := range status.Migrations {
+		im := infoMigration{
+			InstalledRank: ms.InstalledRank,
+			Version:       ms.Version,
+			Description:   ms.Description,
+			Type:          ms.Type,
+			Script:        ms.Script,
+			Repeatable:    ms.Repeatable,
+			// Stored as signed integer in the metadata table
+			Checksum:      int32(ms.Checksum),
+			InstalledBy:   ms.InstalledBy,
+			ExecutionTime: ms.ExecutionTime.Milliseconds(),
+			State:         string(ms.State),
+		}
+		if ms.InstalledRank != 0 {
+			t := ms.InstalledOn
+			im.InstalledOn = &t
+		}
+		out.Migrations = append(out.Migrations, im)
+	}
+	return out
+}
+
+func writeInfoTable(status *fwish.Status) error {
+	currentVersion := status.CurrentVersion
+	if currentVersion == "" {
+		currentVersion = "<< Empty Schema >>"
+	}
+	fmt.Printf("Schema: %s\n", status.SchemaName)
+	fmt.Printf("Schema version: %s\n\n", currentVersion)
+
+	headers := []string{"Category", "Version", "Description", "Type", "Installed On", "Execution Time", "State"}
+	var rows [][]string
+	for _, ms := range status.Migrations {
+		category := "Versioned"
+		switch {
+		case ms.Repeatable:
+			category = "Repeatable"
+		case ms.Type == fwish.MigrationTypeSchema:
+			category = "Schema"
+		}
+		var installedOn, execTime string
+		if ms.InstalledRank != 0 {
+			installedOn = ms.InstalledOn.Format("2006-01-02 15:04:05")
+			execTime = strconv.FormatInt(ms.ExecutionTime.Milliseconds(), 10) + "ms"
+		}
+		rows = append(rows, []string{
+			category, ms.Version, ms.Description, ms.Type, installedOn, execTime, string(ms.State),
+		})
+	}
+
+	return writeTable(os.Stdout, headers, rows)
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputFormatTable = "table"
+	outputFormatJSON  = "json"
+	outputFormatYAML  = "yaml"
+)
+
+// writeStructured writes v to w in the specified machine-readable format.
+func writeStructured(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case outputFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputFormatYAML:
+		enc := yaml.NewEncoder(w)
+		defer enc.Close()
+		return enc.Encode(v)
+	}
+	return fmt.Errorf("unsupported output format %q", format)
+}
+
+// writeTable writes a Flyway-style ASCII table.
+func writeTable(w io.Writer, headers []string, rows [][]string) error {
+	widths := make([]int, len(headers))
+	for i, h := range headers {
+		widths[i] = utf8.RuneCountInString(h)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	var sb strings.Builder
+	separator := func() {
+		sb.WriteString("+")
+		for _, wd := range widths {
+			sb.WriteString(strings.Repeat("-", wd+2))
+			sb.WriteString("+")
+		}
+		sb.WriteString("\n")
+	}
+	line := func(cells []string) {
+		sb.WriteString("|")
+		for i, wd := range widths {
+			cell := cells[i]
+			sb.WriteString(" ")
+			sb.WriteString(cell)
+			sb.WriteString(strings.Repeat(" ", wd-utf8.RuneCountInString(cell)+1))
+			sb.WriteString("|")
+		}
+		sb.WriteString("\n")
+	}
+
+	separator()
+	line(headers)
+	separator()
+	for _, row := range rows {
+		line(row)
+	}
+	separator()
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var repairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Remove failed migrations and realign checksums and descriptions",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.New(os.Stderr, "", log.LstdFlags)
+
+		mg, src, db := setupMigrator(logger)
+
+		schemaName := src.SchemaName()
+
+		actions, err := mg.PlanRepairContext(cmd.Context(), db, "")
+		if err != nil {
+			fatal(logger, err)
+		}
+		if len(actions) == 0 {
+			logger.Printf("Schema %q needs no repair.", schemaName)
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "The following changes will be made to schema %q:\n", schemaName)
+		for _, a := range actions {
+			fmt.Fprintf(os.Stderr, "  - %s\n", a)
+		}
+		if !repairYes && !confirm("Continue?") {
+			logger.Fatal("Repair cancelled.")
+		}
+
+		// Only the confirmed changes
+		actions, err = mg.RepairPlannedContext(cmd.Context(), db, "", actions)
+		if err != nil {
+			fatal(logger, err)
+		}
+
+		logger.Printf("Successfully repaired schema %q (%d changes).",
+			schemaName, len(actions))
+	},
+}
+
+var repairYes bool
+
+func init() {
+	repairCmd.Flags().BoolVarP(&repairYes, "yes", "y", false, "Do not ask for confirmation")
+
+	rootCmd.AddCommand(repairCmd)
+}
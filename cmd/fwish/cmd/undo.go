@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Short: "Revert the applied migrations down to the target version",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.New(os.Stderr, "", log.LstdFlags)
+
+		mg, src, db := setupMigrator(logger)
+
+		t0 := time.Now()
+
+		n, err := mg.UndoContext(cmd.Context(), db, "", undoTarget)
+		if err != nil {
+			fatal(logger, err)
+		}
+
+		schemaName := src.SchemaName()
+
+		if n == 0 {
+			logger.Printf("Schema %q has nothing to undo.",
+				schemaName)
+		} else {
+			logger.Printf("Successfully undid %d migrations of schema %q (execution time %s)",
+				n, schemaName, time.Since(t0).String())
+		}
+	},
+}
+
+var undoTarget string
+
+func init() {
+	undoCmd.Flags().StringVarP(&undoTarget, "target", "t", "", "Version to revert the schema to. Only the latest migration is reverted if not specified")
+
+	rootCmd.AddCommand(undoCmd)
+}
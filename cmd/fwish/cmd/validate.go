@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the applied migrations against the source without migrating",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.New(os.Stderr, "", log.LstdFlags)
+
+		mg, src, db := setupMigrator(logger)
+
+		problems, err := mg.ValidateContext(cmd.Context(), db, "")
+		if err != nil {
+			fatal(logger, err)
+		}
+
+		schemaName := src.SchemaName()
+
+		if len(problems) == 0 {
+			logger.Printf("Successfully validated schema %q.", schemaName)
+			return
+		}
+
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", p.Kind, p.Message)
+		}
+		logger.Printf("Validation of schema %q failed with %d problems.",
+			schemaName, len(problems))
+		os.Exit(exitCode(&problems[0]))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
@@ -0,0 +1,124 @@
+package fwish
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+)
+
+// contextDB returns db as a ContextDB. If db doesn't implement ContextDB,
+// it will be wrapped so that the contexts are ignored.
+func contextDB(db DB) ContextDB {
+	if cdb, ok := db.(ContextDB); ok {
+		return cdb
+	}
+	return legacyDB{db}
+}
+
+// connPinner is implemented by sql.DB and by the types which embed it,
+// e.g., sqlx.DB.
+type connPinner interface {
+	Conn(ctx context.Context) (*sql.Conn, error)
+}
+
+// pinConn replaces the state's DB with a connection dedicated to the
+// operation if the DB is a pool. This guarantees that the session
+// settings, e.g., the search_path, and the migration lock apply to all
+// the statements of the operation, and only to them. Call the returned
+// function, after all the other deferred calls of the operation, to
+// release the connection; it's discarded instead of being returned to
+// the pool as resetting the session would also deallocate the
+// statements prepared by the other users of the pool.
+//
+// ErrConnRequired is returned if the DB is neither a sql.Conn nor
+// a pool.
+func (m *Migrator) pinConn(ctx context.Context, st *state) (release func(), err error) {
+	if _, ok := st.db.(*sql.Conn); ok {
+		return func() {}, nil
+	}
+	var pinner connPinner
+	switch db := st.db.(type) {
+	case connPinner:
+		pinner = db
+	case legacyDB:
+		pinner, _ = db.db.(connPinner)
+	}
+	if pinner == nil {
+		return nil, ErrConnRequired
+	}
+	conn, err := pinner.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	st.db = conn
+
+	return func() {
+		// nolint: errcheck
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}, nil
+}
+
+// legacyDB adapts a DB which has no context-aware methods.
+type legacyDB struct {
+	db DB
+}
+
+func (d legacyDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if opts != nil && *opts != (sql.TxOptions{}) {
+		return nil, errors.New("fwish: DB does not support transaction options")
+	}
+	return d.db.Begin()
+}
+
+func (d legacyDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return d.db.Exec(query, args...)
+}
+
+func (d legacyDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return d.db.Query(query, args...)
+}
+
+func (d legacyDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return d.db.QueryRow(query, args...)
+}
+
+// executorDB adapts an Executor for migration sources which only
+// implement ExecuteMigration. The operations use the context the
+// migration was executed with.
+type executorDB struct {
+	ctx context.Context
+	ex  Executor
+}
+
+func (d executorDB) Begin() (*sql.Tx, error) {
+	if b, ok := d.ex.(interface {
+		BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	}); ok {
+		return b.BeginTx(d.ctx, nil)
+	}
+	return nil, errors.New("fwish: nested transactions are not supported")
+}
+
+func (d executorDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return d.ex.ExecContext(d.ctx, query, args...)
+}
+
+func (d executorDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return d.ex.QueryContext(d.ctx, query, args...)
+}
+
+func (d executorDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return d.ex.QueryRowContext(d.ctx, query, args...)
+}
+
+// executeSourceMigration executes the migration with the source's
+// context-aware method if it has one.
+func executeSourceMigration(
+	ctx context.Context, source MigrationSource, db Executor, migration MigrationInfo,
+) error {
+	if cs, ok := source.(ContextMigrationSource); ok {
+		return cs.ExecuteMigrationContext(ctx, db, migration)
+	}
+	return source.ExecuteMigration(executorDB{ctx, db}, migration)
+}
@@ -0,0 +1,134 @@
+package fwish
+
+import (
+	"fmt"
+)
+
+// MigrationError is returned when the execution of a migration failed.
+// It's formatted like a compiler error, e.g.,
+//
+//	V2__Add_people.sql:14:7: pq: syntax error at or near "TABEL"
+type MigrationError struct {
+	// Version is the version of the migration. It's empty for
+	// repeatable migrations.
+	Version string
+	// Script is the script of the migration, e.g., the file name.
+	Script string
+	// Statement is the statement which failed, if the source could
+	// tell.
+	Statement string
+	// StatementIndex is the 1-based index of the statement in the
+	// script. It's zero if unknown.
+	StatementIndex int
+	// Line and Column, both 1-based, point to the location of the error
+	// in the script. They are zero if the location is unknown.
+	Line   int
+	Column int
+	// Err is the underlying error, e.g., a *pq.Error.
+	Err error
+}
+
+func (e *MigrationError) Error() string {
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %v", e.Script, e.Line, e.Column, e.Err)
+	case e.Line > 0:
+		return fmt.Sprintf("%s:%d: %v", e.Script, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Script, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// ChecksumMismatchError is reported when an applied migration has been
+// modified in the sources afterward.
+type ChecksumMismatchError struct {
+	InstalledRank int32
+	// Version is empty for repeatable migrations.
+	Version string
+	Script  string
+	// Expected is the checksum of the migration in the sources.
+	Expected uint32
+	// Actual is the checksum recorded in the metadata table.
+	Actual uint32
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("fwish: checksum mismatch for rank %d: %s (recorded %d, resolved %d)",
+		e.InstalledRank, e.Script, int32(e.Actual), int32(e.Expected))
+}
+
+// MissingMigrationError is reported when an applied migration is not
+// available in the sources.
+type MissingMigrationError struct {
+	InstalledRank int32
+	// Version is empty for repeatable migrations.
+	Version     string
+	Description string
+	Script      string
+}
+
+func (e *MissingMigrationError) Error() string {
+	return fmt.Sprintf("fwish: applied migration %s not found in the sources: %s",
+		errorMigrationRef(e.Version, e.Description), e.Script)
+}
+
+// UnknownAppliedMigrationError is reported when an applied migration
+// has a version higher than all the migrations in the sources, e.g.,
+// the schema has been migrated by a newer version of the application.
+type UnknownAppliedMigrationError struct {
+	InstalledRank int32
+	Version       string
+	Script        string
+}
+
+func (e *UnknownAppliedMigrationError) Error() string {
+	return fmt.Sprintf("fwish: applied migration %s is newer than the sources: %s",
+		e.Version, e.Script)
+}
+
+// FailedMigrationError is reported when a migration is recorded as
+// failed in the metadata table. It matches ErrSchemaHasFailedMigration
+// with errors.Is.
+type FailedMigrationError struct {
+	InstalledRank int32
+	// Version is empty for repeatable migrations.
+	Version     string
+	Description string
+	Script      string
+}
+
+func (e *FailedMigrationError) Error() string {
+	return fmt.Sprintf("fwish: migration %s failed: %s",
+		errorMigrationRef(e.Version, e.Description), e.Script)
+}
+
+func (e *FailedMigrationError) Unwrap() error {
+	return ErrSchemaHasFailedMigration
+}
+
+// IgnoredMigrationError is reported when a migration in the sources has
+// not been applied but its version is lower than the current version of
+// the schema. Such migration could be applied with the out-of-order
+// mode.
+type IgnoredMigrationError struct {
+	Version        string
+	Script         string
+	CurrentVersion string
+}
+
+func (e *IgnoredMigrationError) Error() string {
+	return fmt.Sprintf("fwish: migration %s is not applied but lower than current version %s: %s",
+		e.Version, e.CurrentVersion, e.Script)
+}
+
+// errorMigrationRef returns a short reference to the migration for
+// messages.
+func errorMigrationRef(version, description string) string {
+	if version == "" {
+		return fmt.Sprintf("%q", description)
+	}
+	return version
+}
@@ -1,11 +1,14 @@
 package fwish
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/lib/pq"
 
@@ -15,6 +18,9 @@ import (
 const (
 	SchemaNameDefault    = "public"
 	MetatableNameDefault = "schema_version"
+	// MetatableNameFlyway is the name of the metadata table used by
+	// Flyway 6 and later. It's the default in the Flyway mode.
+	MetatableNameFlyway = "flyway_schema_history"
 )
 
 // NOTE: the DB's schemaID is the one with the highest authority. if the
@@ -30,6 +36,25 @@ type DB interface {
 	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
+// ContextDB is the context-aware counterpart of DB. It can be fulfilled
+// by sql.DB and sql.Conn instances, and by their stdlib-compatible
+// implementations.
+type ContextDB interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Executor is the interface through which migration sources execute
+// the migrations. It can be fulfilled by sql.DB, sql.Conn and sql.Tx
+// instances.
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 // LogOutputer is an interface for non-structured logger. This
 // interface is compatible with stdlib's Logger.
 type LogOutputer interface {
@@ -44,8 +69,40 @@ type MigrationInfo struct {
 	Name     string
 	Script   string
 	Checksum uint32
+	// NoTransaction tells the migrator to not execute the migration in
+	// a transaction. This is required for statements which can't be
+	// executed in a transaction block, e.g., CREATE INDEX CONCURRENTLY.
+	//
+	// Migrations which are executed without a transaction are recorded
+	// in the metadata table before they are executed so that a failure
+	// could be detected, but their partial effects are not reverted.
+	NoTransaction bool
+	// Timeout limits the execution time of the migration. Zero means
+	// no limit other than the context's.
+	Timeout time.Duration
+	// Placeholders holds the values for the placeholders in the
+	// migration's script, e.g., ${fwish:defaultSchema}. It's provided
+	// by the migrator when executing the migration and it includes the
+	// built-in placeholders and the ones set with WithPlaceholders.
+	Placeholders map[string]string
 }
 
+// The built-in placeholders. Their values are provided by the migrator
+// for every migration.
+const (
+	// PlaceholderDefaultSchema is the schema being migrated.
+	PlaceholderDefaultSchema = "fwish:defaultSchema"
+	// PlaceholderUser is the user ID set with WithUserID.
+	PlaceholderUser = "fwish:user"
+	// PlaceholderTimestamp is the time the migration is executed, in
+	// the same format as Flyway's, e.g., 2006-01-02 15:04:05.
+	PlaceholderTimestamp = "fwish:timestamp"
+	// PlaceholderTable is the name of the metadata table.
+	PlaceholderTable = "fwish:table"
+	// PlaceholderFilename is the script of the migration.
+	PlaceholderFilename = "fwish:filename"
+)
+
 // MigrationSource is an abstraction for migration sources.
 type MigrationSource interface {
 	SchemaID() string
@@ -54,6 +111,15 @@ type MigrationSource interface {
 	ExecuteMigration(db DB, migration MigrationInfo) error
 }
 
+// ContextMigrationSource is an optional interface for migration sources
+// which support cancellation. If a source implements this interface,
+// the migrator will use ExecuteMigrationContext instead of
+// ExecuteMigration.
+type ContextMigrationSource interface {
+	MigrationSource
+	ExecuteMigrationContext(ctx context.Context, db Executor, migration MigrationInfo) error
+}
+
 var (
 	// ErrSchemaIDMismatch is returned when the provided ID doesn't match
 	// schema's ID.
@@ -64,16 +130,70 @@ var (
 	ErrSchemaIndexFileNotFound = errors.New("fwish: schema index file not found")
 
 	ErrSchemaHasFailedMigration = errors.New("fwish: schema has failed migration")
+
+	// ErrSchemaNotEmpty is returned when the schema contains objects but
+	// it has no metadata table. Such schema needs to be baselined first.
+	ErrSchemaNotEmpty = errors.New("fwish: schema is not empty and has no metadata table")
+
+	// ErrGroupNoTransaction is returned when migrating in a group but
+	// some of the pending migrations can't be executed in a transaction.
+	ErrGroupNoTransaction = errors.New("fwish: group migration includes non-transactional migration")
+
+	// ErrConnRequired is returned by the operations which modify the
+	// schema, e.g., Migrate, when the DB is neither a sql.Conn nor a
+	// pool a connection could be obtained from, e.g., a sql.DB. The
+	// operations need a single connection to hold the migration lock
+	// and the session settings.
+	ErrConnRequired = errors.New("fwish: DB provides no dedicated connection")
+
+	// ErrInvalidName is returned, wrapped, when the name of the schema
+	// or the metadata table could not be used as a PostgreSQL
+	// identifier.
+	ErrInvalidName = errors.New("fwish: invalid name")
 )
 
 // Might want store the tx in here too
 type state struct {
-	db            DB
+	db            ContextDB
 	schemaName    string
 	metatableName string
 	installedRank int32
 }
 
+// quotedSchema returns the schema name quoted for use in SQL.
+func (st *state) quotedSchema() string {
+	return pq.QuoteIdentifier(st.schemaName)
+}
+
+// quotedMetatable returns the qualified name of the metadata table
+// quoted for use in SQL.
+func (st *state) quotedMetatable() string {
+	return st.quotedSchema() + "." + pq.QuoteIdentifier(st.metatableName)
+}
+
+// maxIdentifierLen is PostgreSQL's NAMEDATALEN-1. Longer identifiers
+// are truncated by PostgreSQL, which would make us look for the objects
+// under the wrong names.
+const maxIdentifierLen = 63
+
+// validateName checks that name could be used as a PostgreSQL
+// identifier. The names are always quoted, so mixed-case names and
+// reserved words are fine. kind is for the error message.
+func validateName(kind, name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: %s name is empty", ErrInvalidName, kind)
+	case len(name) > maxIdentifierLen:
+		return fmt.Errorf("%w: %s name %q is longer than %d bytes",
+			ErrInvalidName, kind, name, maxIdentifierLen)
+	case !utf8.ValidString(name):
+		return fmt.Errorf("%w: %s name %q is not valid UTF-8", ErrInvalidName, kind, name)
+	case strings.IndexByte(name, 0) >= 0:
+		return fmt.Errorf("%w: %s name %q contains NUL character", ErrInvalidName, kind, name)
+	}
+	return nil
+}
+
 type migration struct {
 	versionStr  string
 	versionInts []int64
@@ -82,6 +202,53 @@ type migration struct {
 	script      string
 	checksum    uint32
 	source      MigrationSource
+	// repeatable migrations have no version. They are identified by
+	// their label.
+	repeatable bool
+	// undo migrations revert the versioned migration of the same version.
+	undo bool
+	// noTransaction migrations are executed without a transaction.
+	noTransaction bool
+	timeout       time.Duration
+}
+
+// info returns the MigrationInfo to be passed to the source.
+func (sf *migration) info() MigrationInfo {
+	return MigrationInfo{
+		Name:          sf.name,
+		Script:        sf.script,
+		Checksum:      sf.checksum,
+		NoTransaction: sf.noTransaction,
+		Timeout:       sf.timeout,
+	}
+}
+
+// migrationError ensures that the error returned by the source is
+// a *MigrationError with the migration's details.
+func (sf *migration) migrationError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var me *MigrationError
+	if !errors.As(err, &me) {
+		return &MigrationError{Version: sf.versionStr, Script: sf.script, Err: err}
+	}
+	if me.Version == "" {
+		me.Version = sf.versionStr
+	}
+	if me.Script == "" {
+		me.Script = sf.script
+	}
+	return err
+}
+
+// migrationType returns the value for the type column of the
+// metadata table.
+func (sf *migration) migrationType() string {
+	if sf.undo {
+		return MigrationTypeUndoSQL
+	}
+	return MigrationTypeSQL
 }
 
 // Migrator is the ..
@@ -93,6 +260,19 @@ type Migrator struct {
 	sources    []MigrationSource
 	versions   []string
 	migrations map[string]migration
+	// Repeatable migrations keyed by their label
+	repeatables      map[string]migration
+	repeatableLabels []string
+	// Undo migrations keyed by their version
+	undos map[string]migration
+
+	allowClean    bool
+	outOfOrder    bool
+	flywayMode    bool
+	metatableName string
+	lockTimeout   time.Duration
+	placeholders  map[string]string
+	callbacks     map[CallbackEvent][]Callback
 
 	logger LogOutputer
 }
@@ -124,6 +304,51 @@ func (m *Migrator) WithUserID(userID string) *Migrator {
 	return m
 }
 
+// WithOutOfOrder sets whether migrations with version lower than the
+// current version of the schema are allowed to be applied. This is
+// useful when migrations are developed in parallel branches, e.g.,
+// a hotfix branch adding version 3.5 after version 4 has been applied.
+//
+// When it's disabled, which is the default, such migrations are
+// reported as ignored and fail the validation.
+func (m *Migrator) WithOutOfOrder(outOfOrder bool) *Migrator {
+	m.outOfOrder = outOfOrder
+	return m
+}
+
+// WithMetatableName sets the name of the metadata table, which is
+// MetatableNameDefault, or MetatableNameFlyway in the Flyway mode, if
+// it's empty.
+func (m *Migrator) WithMetatableName(name string) *Migrator {
+	m.metatableName = name
+	return m
+}
+
+// WithFlywayMode sets whether the metadata table is maintained the way
+// Flyway 6 and later do so that the schemas could be taken over from,
+// or handed back to, Flyway. In the Flyway mode, the metadata table is
+// named MetatableNameFlyway by default, the ranks start at 1 as there's
+// no row for the schema ID, and the creation of the schema is recorded
+// like Flyway does.
+func (m *Migrator) WithFlywayMode(flywayMode bool) *Migrator {
+	m.flywayMode = flywayMode
+	return m
+}
+
+// WithPlaceholders sets the values for the placeholders in the
+// migrations' scripts. The values are merged with the ones set before.
+// The built-in placeholders, e.g., PlaceholderDefaultSchema, could not
+// be overridden.
+func (m *Migrator) WithPlaceholders(placeholders map[string]string) *Migrator {
+	if m.placeholders == nil {
+		m.placeholders = map[string]string{}
+	}
+	for k, v := range placeholders {
+		m.placeholders[k] = v
+	}
+	return m
+}
+
 // AddSource register a migrations provider. The source must have the same
 // schema ID as the migrator.
 //
@@ -135,9 +360,11 @@ func (m *Migrator) AddSource(src MigrationSource) error {
 	// apply the changes after all have been validated.
 
 	//TODO: get the schemaName from the source with first rank
-	if m.schemaName == "" {
+	if m.schemaName == "" && src.SchemaName() != "" {
+		if err := validateName("schema", src.SchemaName()); err != nil {
+			return err
+		}
 		m.schemaName = src.SchemaName()
-		//TODO: ensure valid schema name
 	}
 	id := src.SchemaID()
 	if m.schemaID != "" {
@@ -156,197 +383,484 @@ func (m *Migrator) AddSource(src MigrationSource) error {
 	if m.migrations == nil {
 		m.migrations = make(map[string]migration)
 	}
+	if m.repeatables == nil {
+		m.repeatables = make(map[string]migration)
+	}
+	if m.undos == nil {
+		m.undos = make(map[string]migration)
+	}
 
 	migrationVersionSeparator := "__"
 	migrationVersionedPrefix := "V"
+	migrationRepeatablePrefix := "R"
+	migrationUndoPrefix := "U"
 
 	for _, mi := range ml {
 		mn := mi.Name
-		if !strings.HasPrefix(mn, migrationVersionedPrefix) {
-			return fmt.Errorf("fwish: migration name %q has invalid prefix", mn)
-		}
-		idx := strings.Index(mn, migrationVersionSeparator)
-		if idx == -1 {
-			return fmt.Errorf("fwish: invalid migration name %q", mn)
-		}
-		vstr := mn[:idx]
-		label := strings.TrimSpace(
-			strings.Replace(
-				mn[idx+len(migrationVersionSeparator):], "_", " ", -1))
-
-		vstr = vstr[len(migrationVersionedPrefix):]
-		if vstr == "" {
-			return fmt.Errorf("fwish: migration name %q has invalid version part", mn)
-		}
+		switch {
+		case strings.HasPrefix(mn, migrationRepeatablePrefix+migrationVersionSeparator):
+			label := migrationLabel(mn[len(migrationRepeatablePrefix+migrationVersionSeparator):])
+			if label == "" {
+				return fmt.Errorf("fwish: repeatable migration name %q has no description", mn)
+			}
+			if cv, ok := m.repeatables[label]; ok {
+				return fmt.Errorf("fwish: repeatable migration %q conflict (%q, %q)", label, cv.name, mn)
+			}
+			m.repeatables[label] = migration{
+				label:         label,
+				name:          mn,
+				script:        mi.Script,
+				checksum:      mi.Checksum,
+				source:        src,
+				repeatable:    true,
+				noTransaction: mi.NoTransaction,
+				timeout:       mi.Timeout,
+			}
+			m.repeatableLabels = append(m.repeatableLabels, label)
 
-		vints, err := version.Parse(vstr)
-		if err != nil {
-			return err
-		}
-		vstr = vints.String()
-		if vstr == "" {
-			// This would be an internal error
-			return fmt.Errorf("fwish: migration %q has empty version", mn)
-		}
+		case strings.HasPrefix(mn, migrationUndoPrefix):
+			vints, label, err := parseVersionedName(mn, migrationUndoPrefix, migrationVersionSeparator)
+			if err != nil {
+				return err
+			}
+			vstr := vints.String()
+			if cv, ok := m.undos[vstr]; ok {
+				return fmt.Errorf("fwish: undo version %q conflict (%q, %q)", vstr, cv.name, mn)
+			}
+			m.undos[vstr] = migration{
+				versionStr:    vstr,
+				versionInts:   vints,
+				label:         label,
+				name:          mn,
+				script:        mi.Script,
+				checksum:      mi.Checksum,
+				source:        src,
+				undo:          true,
+				noTransaction: mi.NoTransaction,
+				timeout:       mi.Timeout,
+			}
 
-		if cv, ok := m.migrations[vstr]; ok {
-			//TODO: test case for this
-			return fmt.Errorf("fwish: version %q conflict (%q, %q)", vstr, cv.name, mn)
-		}
-		m.migrations[vstr] = migration{
-			versionStr:  vstr,
-			versionInts: vints,
-			label:       label,
-			name:        mn,
-			script:      mi.Script,
-			checksum:    mi.Checksum,
-			source:      src,
+		case strings.HasPrefix(mn, migrationVersionedPrefix):
+			vints, label, err := parseVersionedName(mn, migrationVersionedPrefix, migrationVersionSeparator)
+			if err != nil {
+				return err
+			}
+			vstr := vints.String()
+			if cv, ok := m.migrations[vstr]; ok {
+				//TODO: test case for this
+				return fmt.Errorf("fwish: version %q conflict (%q, %q)", vstr, cv.name, mn)
+			}
+			m.migrations[vstr] = migration{
+				versionStr:    vstr,
+				versionInts:   vints,
+				label:         label,
+				name:          mn,
+				script:        mi.Script,
+				checksum:      mi.Checksum,
+				source:        src,
+				noTransaction: mi.NoTransaction,
+				timeout:       mi.Timeout,
+			}
+			m.versions = append(m.versions, vstr)
+
+		default:
+			return fmt.Errorf("fwish: migration name %q has invalid prefix", mn)
 		}
-		m.versions = append(m.versions, vstr)
 	}
 
 	err = version.SortStrings(m.versions)
 	if err != nil {
 		return err
 	}
+	// Repeatable migrations are applied in the order of their labels
+	sort.Strings(m.repeatableLabels)
 
 	m.sources = append(m.sources, src)
 
 	return nil
 }
 
+// parseVersionedName parses the name of a versioned or an undo migration
+// into its version and its label.
+func parseVersionedName(mn, prefix, separator string) (version.Version, string, error) {
+	idx := strings.Index(mn, separator)
+	if idx == -1 {
+		return nil, "", fmt.Errorf("fwish: invalid migration name %q", mn)
+	}
+	vstr := mn[len(prefix):idx]
+	label := migrationLabel(mn[idx+len(separator):])
+
+	if vstr == "" {
+		return nil, "", fmt.Errorf("fwish: migration name %q has invalid version part", mn)
+	}
+
+	vints, err := version.Parse(vstr)
+	if err != nil {
+		return nil, "", err
+	}
+	if vints.String() == "" {
+		// This would be an internal error
+		return nil, "", fmt.Errorf("fwish: migration %q has empty version", mn)
+	}
+
+	return vints, label, nil
+}
+
+// migrationLabel returns the description part of a migration name in
+// human-readable form.
+func migrationLabel(s string) string {
+	return strings.TrimSpace(strings.Replace(s, "_", " ", -1))
+}
+
 // SchemaID returns the ID of the schema the migrations are for.
 func (m *Migrator) SchemaID() string { return m.schemaID }
 
+// Symbolic targets for MigrateOptions.Target.
+const (
+	// MigrateTargetLatest applies all the pending migrations.
+	MigrateTargetLatest = "latest"
+	// MigrateTargetCurrent applies no versioned migrations. Only the
+	// repeatable migrations are applied.
+	MigrateTargetCurrent = "current"
+	// MigrateTargetNext applies only the next pending versioned migration.
+	MigrateTargetNext = "next"
+)
+
+// MigrateOptions holds the options for MigrateWithOptions.
+type MigrateOptions struct {
+	// Target is the version up to which the versioned migrations will
+	// be applied. It accepts a version string or one of the symbolic
+	// targets: MigrateTargetLatest, MigrateTargetCurrent and
+	// MigrateTargetNext. Empty means MigrateTargetLatest.
+	//
+	// The target does not affect repeatable migrations.
+	Target string
+
+	// Group executes all the pending migrations in a single
+	// transaction so that either all of them are applied or none of
+	// them. It fails with ErrGroupNoTransaction if any of the pending
+	// migrations can't be executed in a transaction.
+	Group bool
+}
+
 // Migrate execute the migrations.
 //
+// Concurrent migrations of the same schema, e.g., from multiple instances
+// of an application, are serialized with a PostgreSQL advisory lock. The
+// instances which have been waiting for the lock will re-validate the
+// schema, and they will find nothing to apply. See WithLockTimeout.
+//
 // The schemaName parameter will be used to override the schema name
 // found inside the meta file. The schema name corresponds the
 // Postgres database schema name.
 func (m *Migrator) Migrate(db DB, schemaName string) (num int, err error) {
-	//TODO: validate the parameters
-	// - we should use regex for schemaName. [A-Za-z0-9_]
-	//TODO: use source's schemaName as the default?
-	if schemaName == "" {
-		schemaName = m.schemaName
+	return m.MigrateWithOptions(db, schemaName, MigrateOptions{})
+}
+
+// MigrateWithOptions execute the migrations with the provided options.
+//
+// The schemaName parameter has the same semantic as Migrate's.
+func (m *Migrator) MigrateWithOptions(db DB, schemaName string, opts MigrateOptions) (num int, err error) {
+	return m.MigrateContext(context.Background(), contextDB(db), schemaName, opts)
+}
+
+// MigrateContext execute the migrations with the provided options. The
+// context is used for all the operations, including the execution of
+// the migrations; cancelling it will abort the migration which is
+// being executed.
+//
+// If db is a pool, e.g., a sql.DB, the migrations are executed in a
+// connection dedicated to them, and the connection is discarded
+// afterward so that the session settings, e.g., the search_path, the
+// role and the timeouts, don't leak into the other users of the pool.
+// A sql.Conn could be provided instead to have the migrations executed
+// in it, in which case only the search_path will be restored. Other
+// DBs are rejected with ErrConnRequired.
+//
+// If an afterMigrate callback failed, the number of the migrations,
+// which have been applied nonetheless, is returned with the error.
+//
+// The schemaName parameter has the same semantic as Migrate's.
+func (m *Migrator) MigrateContext(
+	ctx context.Context, db ContextDB, schemaName string, opts MigrateOptions,
+) (num int, err error) {
+	st, err := m.newState(db, schemaName)
+	if err != nil {
+		return -1, err
 	}
-	if schemaName == "" {
-		schemaName = SchemaNameDefault
+
+	releaseConn, err := m.pinConn(ctx, st)
+	if err != nil {
+		return -1, err
 	}
-	st := &state{db, schemaName, MetatableNameDefault, -1}
+	defer releaseConn()
 
-	var searchPath string
-	err = st.db.QueryRow("SHOW search_path").Scan(&searchPath)
+	releaseLock, err := m.acquireLock(ctx, st)
 	if err != nil {
 		return -1, err
 	}
+	defer releaseLock()
 
-	_, err = st.db.Exec("SET search_path TO " + st.schemaName)
+	restoreSearchPath, err := m.useSchema(ctx, st)
 	if err != nil {
 		return -1, err
 	}
-	defer func() {
-		_, err := st.db.Exec("SET search_path TO " + searchPath)
-		if err != nil {
-			if logger := m.logger; logger != nil {
-				logger.Output(2, "SET search_path returned error: "+err.Error())
-			}
-		}
-	}()
+	defer restoreSearchPath()
+
+	num, err = m.migrate(ctx, st, opts)
+	if err != nil {
+		m.runErrorCallbacks(ctx, st, CallbackAfterMigrateError, nil, err)
+		return -1, err
+	}
+
+	err = m.runCallbacks(ctx, st.db, st, CallbackAfterMigrate, nil, CallbackInfo{NumApplied: num})
+	if err != nil {
+		// The migrations have been applied nonetheless
+		m.runErrorCallbacks(ctx, st, CallbackAfterMigrateError, nil, err)
+		return num, err
+	}
+
+	return num, nil
+}
 
-	err = m.validateDBSchema(st)
+// migrate validates the schema and executes the pending migrations. The
+// beforeMigrate callbacks are executed once the metadata table is
+// ready, like Flyway does.
+func (m *Migrator) migrate(ctx context.Context, st *state, opts MigrateOptions) (num int, err error) {
+	status, err := m.validateDBSchema(ctx, st)
 	if err != nil {
 		return -1, err
 	}
 
+	pending, err := m.targetMigrations(status, m.pendingMigrations(status), opts.Target)
+	if err != nil {
+		return -1, err
+	}
+	// Rejected before anything is changed
+	if opts.Group {
+		for _, sf := range pending {
+			if sf.noTransaction {
+				return -1, fmt.Errorf("%w: %s", ErrGroupNoTransaction, sf.name)
+			}
+		}
+	}
+
 	if st.installedRank == -1 {
-		err = m.ensureDBSchemaInitialized(st)
+		empty, err := isSchemaEmpty(ctx, st)
+		if err != nil {
+			return -1, err
+		}
+		if !empty {
+			return -1, ErrSchemaNotEmpty
+		}
+		err = m.ensureDBSchemaInitialized(ctx, st)
 		if err != nil {
 			return -1, err
 		}
 	}
 
-	// All in a Tx?
-	for i := int(st.installedRank); i < len(m.versions); i++ {
-		sf := m.migrations[m.versions[i]]
-		if m.logger != nil {
-			// nolint: errcheck
-			m.logger.Output(2, fmt.Sprintf(
-				"Migrating schema %q to version %s - %s",
-				st.schemaName, sf.versionStr, sf.label,
-			))
-		}
-		err = m.executeMigration(st, int32(i+1), &sf)
+	err = m.runCallbacks(ctx, st.db, st, CallbackBeforeMigrate, nil, CallbackInfo{})
+	if err != nil {
+		return -1, err
+	}
+
+	if opts.Group {
+		return m.migrateGroup(ctx, st, pending)
+	}
+
+	for _, sf := range pending {
+		m.logMigration(st, &sf)
+		err = m.executeMigration(ctx, st, st.installedRank+1, &sf)
 		if err != nil {
 			return -1, err
 		}
+		st.installedRank++
 		num++
 	}
 
 	return num, nil
 }
 
-// Status returns whether all the migrations have been applied.
-//
-// TODO: return a struct.
-func (m *Migrator) Status(db DB) (diff int, err error) {
-	// if err := m.ensureSourceFilesScanned(); err != nil {
-	// 	return 0, err
-	// }
+// migrateGroup executes the migrations in a single transaction. None
+// of them could be non-transactional.
+func (m *Migrator) migrateGroup(ctx context.Context, st *state, pending []migration) (int, error) {
+	if len(pending) == 0 {
+		return 0, nil
+	}
 
-	// SELECT * FROM table WHERE status IS TRUE
+	var failed *migration
+	err := doTx(ctx, st.db, func(tx *sql.Tx) error {
+		for i := range pending {
+			m.logMigration(st, &pending[i])
+			err := m.executeMigrationTx(ctx, tx, st, st.installedRank+1+int32(i), &pending[i])
+			if err != nil {
+				failed = &pending[i]
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if failed != nil {
+			m.runErrorCallbacks(ctx, st, CallbackAfterEachMigrateError, failed, err)
+		}
+		return -1, err
+	}
 
-	return 0, errors.New("not implemented yet")
+	st.installedRank += int32(len(pending))
+	return len(pending), nil
 }
 
-func (m *Migrator) ensureDBSchemaInitialized(st *state) error {
-	err := doTx(st.db, func(tx *sql.Tx) error {
-		// NOTE: if the meta table does not exist or there's no revision but
-		// the schema already has other tables, we should return error.
-		// NOTE: if the DB has no schema meta but already has entries,
-		// we assume that it's a from fw. if the migrator has valid
-		// schemaID, set the meta, otherwise we don't bother with schemaID.
+func (m *Migrator) logMigration(st *state, sf *migration) {
+	if sf.repeatable {
+		m.logf("Migrating schema %q with repeatable migration %s",
+			st.schemaName, sf.label)
+	} else {
+		m.logf("Migrating schema %q to version %s - %s",
+			st.schemaName, sf.versionStr, sf.label)
+	}
+}
 
-		_, err := tx.Exec(fmt.Sprintf(
-			`CREATE SCHEMA IF NOT EXISTS %s`,
-			st.schemaName,
-		))
+// useSchema sets the search_path to the schema so that the migrations
+// could refer to objects without qualifying them. It returns a function
+// to restore the previous search_path.
+func (m *Migrator) useSchema(ctx context.Context, st *state) (restore func(), err error) {
+	var searchPath string
+	err = st.db.QueryRowContext(ctx, "SHOW search_path").Scan(&searchPath)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = st.db.ExecContext(ctx, "SET search_path TO "+st.quotedSchema())
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		// Restore it even if the context has been cancelled
+		_, err := st.db.ExecContext(context.WithoutCancel(ctx),
+			"SET search_path TO "+searchPath)
 		if err != nil {
-			pqErr, ok := err.(*pq.Error)
-			if !ok {
-				return err
-			}
-			if pqErr.Code != "42P06" || !strings.Contains(pqErr.Message,
-				`"`+st.schemaName+`"`) {
-				return pqErr
-			}
+			m.logf("SET search_path returned error: %v", err)
 		}
+	}, nil
+}
 
-		_, err = tx.Exec(fmt.Sprintf(
-			`CREATE TABLE IF NOT EXISTS %s.%s (
-			installed_rank integer NOT NULL,
-			version character varying(50),
-			description character varying(200) NOT NULL,
-			type character varying(20) NOT NULL,
-			script character varying(1000) NOT NULL,
-			checksum integer,
-			installed_by character varying(100) NOT NULL,
-			installed_on timestamp without time zone NOT NULL DEFAULT now(),
-			execution_time integer NOT NULL,
-			success boolean NOT NULL,
-			CONSTRAINT %s_pk PRIMARY KEY (installed_rank)
-		)`,
-			st.schemaName, st.metatableName, st.metatableName,
-		))
+// pendingMigrations returns the migrations which need to be applied in
+// the order they should be applied: the versioned migrations ordered by
+// their version then the repeatable migrations ordered by their label.
+func (m *Migrator) pendingMigrations(status *Status) []migration {
+	var versioned, repeatable []migration
+	for _, ms := range status.Migrations {
+		switch {
+		case ms.Repeatable &&
+			(ms.State == MigrationStatePending || ms.State == MigrationStateOutdated):
+			repeatable = append(repeatable, m.repeatables[ms.Description])
+		case !ms.Repeatable && ms.State == MigrationStatePending:
+			versioned = append(versioned, m.migrations[ms.Version])
+		}
+	}
+	sort.SliceStable(repeatable, func(i, j int) bool {
+		return repeatable[i].label < repeatable[j].label
+	})
+	return append(versioned, repeatable...)
+}
+
+// targetMigrations filters the pending migrations so that only the
+// versioned migrations up to the target are kept.
+func (m *Migrator) targetMigrations(
+	status *Status, pending []migration, target string,
+) ([]migration, error) {
+	var targetVersion version.Version
+	switch target {
+	case "", MigrateTargetLatest:
+		return pending, nil
+	case MigrateTargetCurrent, MigrateTargetNext:
+	default:
+		v, err := version.Parse(target)
 		if err != nil {
+			return nil, fmt.Errorf("fwish: invalid target %q: %w", target, err)
+		}
+		targetVersion = v
+		if current, _ := version.Parse(status.CurrentVersion); version.Compare(current, v) > 0 {
+			m.logf("Schema %q is ahead of the target version %s (current version %s)",
+				status.SchemaName, v.String(), status.CurrentVersion)
+		}
+	}
+
+	var result []migration
+	var numVersioned int
+	for _, sf := range pending {
+		if !sf.repeatable {
+			switch target {
+			case MigrateTargetCurrent:
+				continue
+			case MigrateTargetNext:
+				if numVersioned > 0 {
+					continue
+				}
+			default:
+				if version.Compare(sf.versionInts, targetVersion) > 0 {
+					continue
+				}
+			}
+			numVersioned++
+		}
+		result = append(result, sf)
+	}
+
+	return result, nil
+}
+
+func (m *Migrator) newState(db ContextDB, schemaName string) (*state, error) {
+	if schemaName == "" {
+		schemaName = m.schemaName
+	}
+	if schemaName == "" {
+		schemaName = SchemaNameDefault
+	}
+	metatableName := m.metatableName
+	if metatableName == "" {
+		metatableName = MetatableNameDefault
+		if m.flywayMode {
+			metatableName = MetatableNameFlyway
+		}
+	}
+	if err := validateName("schema", schemaName); err != nil {
+		return nil, err
+	}
+	if err := validateName("metadata table", metatableName); err != nil {
+		return nil, err
+	}
+	// The index names are derived from the table name
+	if err := validateName("metadata table", metatableName+"_s_idx"); err != nil {
+		return nil, err
+	}
+	return &state{db, schemaName, metatableName, -1}, nil
+}
+
+func (m *Migrator) ensureDBSchemaInitialized(ctx context.Context, st *state) error {
+	if m.flywayMode {
+		return m.ensureFlywayMetatable(ctx, st)
+	}
+
+	err := doTx(ctx, st.db, func(tx *sql.Tx) error {
+		// NOTE: if the DB has no schema meta but already has entries,
+		// we assume that it's a from fw. if the migrator has valid
+		// schemaID, set the meta, otherwise we don't bother with schemaID.
+
+		if _, err := createDBSchema(ctx, tx, st); err != nil {
+			return err
+		}
+		if err := createMetatable(ctx, tx, st); err != nil {
 			return err
 		}
 
 		var idstr string
 
-		err = tx.QueryRow(fmt.Sprintf(
-			`SELECT script FROM %s.%s WHERE installed_rank=0`,
-			st.schemaName, st.metatableName,
+		err := tx.QueryRowContext(ctx, fmt.Sprintf(
+			`SELECT script FROM %s WHERE installed_rank=0`,
+			st.quotedMetatable(),
 		)).Scan(&idstr)
 		if err == nil {
 			if idstr != m.schemaID {
@@ -359,11 +873,9 @@ func (m *Migrator) ensureDBSchemaInitialized(st *state) error {
 			return err
 		}
 
-		//TODO: ensure indexes
-
-		_, err = tx.Exec(
+		_, err = tx.ExecContext(ctx,
 			fmt.Sprintf(
-				`INSERT INTO %s.%s (
+				`INSERT INTO %s (
 				installed_rank,
 				version,
 				description,
@@ -375,7 +887,7 @@ func (m *Migrator) ensureDBSchemaInitialized(st *state) error {
 				execution_time,
 				success )
 			VALUES (0,$1,$2,'meta',$3,0,$4,$5,0,true)`,
-				st.schemaName, st.metatableName,
+				st.quotedMetatable(),
 			),
 			"0", st.schemaName, m.schemaID, m.userID, time.Now().UTC(),
 		)
@@ -395,106 +907,222 @@ func (m *Migrator) ensureDBSchemaInitialized(st *state) error {
 	return nil
 }
 
-func (m *Migrator) validateDBSchema(st *state) error {
-	st.installedRank = -1
+// ensureFlywayMetatable is ensureDBSchemaInitialized for the Flyway
+// mode. There's no meta row; if the schema is created, it's recorded
+// with a SCHEMA row at rank 1, like Flyway does.
+func (m *Migrator) ensureFlywayMetatable(ctx context.Context, st *state) error {
+	var installedRank int32
+	err := doTx(ctx, st.db, func(tx *sql.Tx) error {
+		created, err := createDBSchema(ctx, tx, st)
+		if err != nil {
+			return err
+		}
+		if err = createMetatable(ctx, tx, st); err != nil {
+			return err
+		}
 
-	//TODO: lazy-load source migration checksums
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS %s ON %s (success)`,
+			pq.QuoteIdentifier(st.metatableName+"_s_idx"), st.quotedMetatable(),
+		))
+		if err != nil {
+			return err
+		}
 
-	rows, err := st.db.Query(fmt.Sprintf(
-		`SELECT installed_rank, version, script, checksum, success
-		FROM %s.%s ORDER BY installed_rank`,
-		st.schemaName, st.metatableName,
+		if created {
+			_, err = tx.ExecContext(ctx,
+				fmt.Sprintf(
+					`INSERT INTO %s (
+					installed_rank,
+					version,
+					description,
+					type,
+					script,
+					checksum,
+					installed_by,
+					installed_on,
+					execution_time,
+					success )
+				VALUES (1,NULL,$1,$2,$3,NULL,$4,$5,0,true)`,
+					st.quotedMetatable(),
+				),
+				SchemaCreationDescription, MigrationTypeSchema,
+				st.quotedSchema(), m.userID, time.Now().UTC(),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.QueryRowContext(ctx, fmt.Sprintf(
+			`SELECT COALESCE(MAX(installed_rank), 0) FROM %s`,
+			st.quotedMetatable(),
+		)).Scan(&installedRank)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	st.installedRank = installedRank
+
+	return nil
+}
+
+// createDBSchema creates the schema if it doesn't exist. It returns
+// true if the schema has been created.
+func createDBSchema(ctx context.Context, tx *sql.Tx, st *state) (created bool, err error) {
+	err = tx.QueryRowContext(ctx,
+		`SELECT NOT EXISTS (SELECT 1 FROM pg_catalog.pg_namespace WHERE nspname = $1)`,
+		st.schemaName,
+	).Scan(&created)
+	if err != nil || !created {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(
+		`CREATE SCHEMA IF NOT EXISTS %s`,
+		st.quotedSchema(),
 	))
 	if err != nil {
 		pqErr, ok := err.(*pq.Error)
 		if !ok {
-			return err
+			return false, err
 		}
-		// 42P01: undefined_table
-		if pqErr.Code != "42P01" ||
-			!strings.Contains(pqErr.Message, `"`+st.schemaName+`.`+st.metatableName+`"`) {
-			return err
+		if pqErr.Code != "42P06" || !strings.Contains(pqErr.Message,
+			`"`+st.schemaName+`"`) {
+			return false, pqErr
 		}
-		return nil
+		// Created by someone else in the meantime
+		return false, nil
+	}
+	return true, nil
+}
+
+// createMetatable creates the metadata table if it doesn't exist. The
+// table is the same as Flyway's.
+func createMetatable(ctx context.Context, tx *sql.Tx, st *state) error {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (
+			installed_rank integer NOT NULL,
+			version character varying(50),
+			description character varying(200) NOT NULL,
+			type character varying(20) NOT NULL,
+			script character varying(1000) NOT NULL,
+			checksum integer,
+			installed_by character varying(100) NOT NULL,
+			installed_on timestamp without time zone NOT NULL DEFAULT now(),
+			execution_time integer NOT NULL,
+			success boolean NOT NULL,
+			CONSTRAINT %s PRIMARY KEY (installed_rank)
+		)`,
+		st.quotedMetatable(), pq.QuoteIdentifier(st.metatableName+"_pk"),
+	))
+	return err
+}
+
+// isSchemaEmpty returns true if the schema does not exist or if it has
+// no objects other than the metadata table.
+func isSchemaEmpty(ctx context.Context, st *state) (bool, error) {
+	var exists bool
+	err := st.db.QueryRowContext(ctx,
+		`SELECT EXISTS (
+			SELECT 1 FROM pg_catalog.pg_class c
+				JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+				WHERE n.nspname = $1 AND c.relname::text NOT IN ($2::text, $2::text || '_pk', $2::text || '_s_idx')
+			UNION ALL
+			SELECT 1 FROM pg_catalog.pg_proc p
+				JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+				WHERE n.nspname = $1
+			UNION ALL
+			SELECT 1 FROM pg_catalog.pg_type t
+				JOIN pg_catalog.pg_namespace n ON n.oid = t.typnamespace
+				WHERE n.nspname = $1 AND t.typtype IN ('d', 'e', 'r')
+		)`,
+		st.schemaName, st.metatableName,
+	).Scan(&exists)
+	if err != nil {
+		return false, err
 	}
-	defer rows.Close()
+	return !exists, nil
+}
 
-	var i, rank int32
-	var version, script string
-	var checksum int32
-	var success bool
+// validateDBSchema validates the metadata table against the sources
+// and returns the first problem found as the error.
+func (m *Migrator) validateDBSchema(ctx context.Context, st *state) (*Status, error) {
+	st.installedRank = -1
 
-	for i = 0; rows.Next(); i++ {
-		err = rows.Scan(&rank, &version, &script, &checksum, &success)
-		if err != nil {
-			return err
-		}
+	//TODO: lazy-load source migration checksums
 
-		if rank != i {
-			// class: schema consistency
-			return errors.New("fwish: insequential installed_rank")
-		}
+	rows, err := readHistory(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > 0 {
+		st.installedRank = rows[len(rows)-1].installedRank
+	}
 
-		if !success {
-			return ErrSchemaHasFailedMigration
-		}
+	status := m.resolveStatus(st, rows)
 
-		if int(i) > len(m.versions) {
-			//TODO: a test for this case
-			return errors.New("fwish: DB has more migrations than the source")
-		}
+	if problems := m.validateHistory(rows, status); len(problems) > 0 {
+		return nil, &problems[0]
+	}
 
-		if i == 0 {
-			continue
-		}
+	return status, nil
+}
 
-		mig := m.migrations[m.versions[i-1]]
+func (m *Migrator) executeMigration(ctx context.Context, st *state, rank int32, sf *migration) error {
+	var err error
+	if sf.noTransaction {
+		err = m.executeMigrationNoTx(ctx, st, rank, sf)
+	} else {
+		// The migration and its history row are committed together so
+		// that a failed migration leaves no trace.
+		err = doTx(ctx, st.db, func(tx *sql.Tx) error {
+			return m.executeMigrationTx(ctx, tx, st, rank, sf)
+		})
+	}
+	if err != nil && !sf.undo {
+		m.runErrorCallbacks(ctx, st, CallbackAfterEachMigrateError, sf, err)
+	}
+	return err
+}
 
-		if mig.checksum != uint32(checksum) {
-			return fmt.Errorf("fwish: checksum mismatch for rank %d: %s", i, script)
-		}
+// executeMigrationTx executes the migration and records it in the
+// metadata table within the transaction.
+func (m *Migrator) executeMigrationTx(
+	ctx context.Context, tx *sql.Tx, st *state, rank int32, sf *migration,
+) error {
+	tStart := time.Now()
 
-		// check other stuff?
+	// A previous migration might have changed the search_path set by
+	// useSchema.
+	_, err := tx.ExecContext(ctx, "SET LOCAL search_path TO "+st.quotedSchema())
+	if err != nil {
+		return err
+	}
 
-		st.installedRank = i
+	err = m.runMigrationWithCallbacks(ctx, tx, st, sf)
+	if err != nil {
+		return err
 	}
 
-	return rows.Err()
+	return m.insertHistoryRow(ctx, tx, st, rank, sf, tStart, time.Since(tStart), true)
 }
 
-func (m *Migrator) executeMigration(st *state, rank int32, sf *migration) error {
+// executeMigrationNoTx executes a migration which can't be executed
+// in a transaction.
+func (m *Migrator) executeMigrationNoTx(ctx context.Context, st *state, rank int32, sf *migration) error {
 	tStart := time.Now()
 
 	// Insert the row first but with success flag set as false. This is
 	// so that we will know when a migration has failed.
-	_, err := st.db.Exec(
-		fmt.Sprintf(
-			`INSERT INTO %s.%s (
-				installed_rank,
-				version,
-				description,
-				type,
-				script,
-				checksum,
-				installed_by,
-				installed_on,
-				execution_time,
-				success )
-			VALUES ($1,$2,$3,'SQL',$4,$5,$6,$7,$8,false)`,
-			st.schemaName, st.metatableName,
-		),
-		rank, sf.versionStr, sf.label, sf.script, int32(sf.checksum),
-		m.userID, tStart.UTC(), 0,
-	)
+	err := m.insertHistoryRow(ctx, st.db, st, rank, sf, tStart, 0, false)
 	if err != nil {
 		return err
 	}
 
-	err = sf.source.ExecuteMigration(st.db, MigrationInfo{
-		Name:     sf.name,
-		Script:   sf.script,
-		Checksum: sf.checksum,
-	})
+	err = m.runMigrationWithCallbacks(ctx, st.db, st, sf)
 	if err != nil {
 		return err
 	}
@@ -502,15 +1130,15 @@ func (m *Migrator) executeMigration(st *state, rank int32, sf *migration) error
 	dt := time.Since(tStart) / time.Millisecond
 
 	// Update the row to indicate that it's was a success.
-	_, err = st.db.Exec(
+	_, err = st.db.ExecContext(ctx,
 		fmt.Sprintf(
-			`UPDATE %s.%s
+			`UPDATE %s
 				SET (
 					execution_time,
 					success )
 				= ($1,true)
 				WHERE installed_rank=$2 AND success IS FALSE`,
-			st.schemaName, st.metatableName,
+			st.quotedMetatable(),
 		),
 		dt, rank,
 	)
@@ -518,8 +1146,100 @@ func (m *Migrator) executeMigration(st *state, rank int32, sf *migration) error
 	return err
 }
 
-func doTx(db DB, txFunc func(*sql.Tx) error) error {
-	tx, err := db.Begin()
+// runMigrationWithCallbacks executes the migration surrounded by the
+// beforeEachMigrate and afterEachMigrate callbacks. Undo migrations
+// have no callbacks.
+func (m *Migrator) runMigrationWithCallbacks(ctx context.Context, db Executor, st *state, sf *migration) error {
+	if sf.undo {
+		return m.runMigration(ctx, db, st, sf)
+	}
+	err := m.runCallbacks(ctx, db, st, CallbackBeforeEachMigrate, sf, CallbackInfo{})
+	if err != nil {
+		return err
+	}
+	err = m.runMigration(ctx, db, st, sf)
+	if err != nil {
+		return err
+	}
+	return m.runCallbacks(ctx, db, st, CallbackAfterEachMigrate, sf, CallbackInfo{})
+}
+
+// runMigration executes the migration with its source, applying the
+// migration's timeout.
+func (m *Migrator) runMigration(ctx context.Context, db Executor, st *state, sf *migration) error {
+	mi := sf.info()
+	mi.Placeholders = m.migrationPlaceholders(st, sf)
+
+	if sf.timeout <= 0 {
+		return sf.migrationError(executeSourceMigration(ctx, sf.source, db, mi))
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, sf.timeout)
+	defer cancel()
+
+	err := sf.migrationError(executeSourceMigration(tctx, sf.source, db, mi))
+	if err != nil && ctx.Err() == nil && tctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("fwish: migration %s timed out after %s: %w",
+			sf.name, sf.timeout, err)
+	}
+	return err
+}
+
+// migrationPlaceholders returns the placeholder values for executing
+// the migration. sf could be nil, e.g., for callbacks.
+func (m *Migrator) migrationPlaceholders(st *state, sf *migration) map[string]string {
+	placeholders := make(map[string]string, len(m.placeholders)+5)
+	for k, v := range m.placeholders {
+		placeholders[k] = v
+	}
+	placeholders[PlaceholderDefaultSchema] = st.schemaName
+	placeholders[PlaceholderUser] = m.userID
+	placeholders[PlaceholderTimestamp] = time.Now().Format("2006-01-02 15:04:05")
+	placeholders[PlaceholderTable] = st.metatableName
+	if sf != nil {
+		placeholders[PlaceholderFilename] = sf.script
+	}
+	return placeholders
+}
+
+// insertHistoryRow records the migration in the metadata table.
+func (m *Migrator) insertHistoryRow(
+	ctx context.Context, db Executor, st *state, rank int32, sf *migration,
+	installedOn time.Time, executionTime time.Duration, success bool,
+) error {
+	_, err := db.ExecContext(ctx,
+		fmt.Sprintf(
+			`INSERT INTO %s (
+				installed_rank,
+				version,
+				description,
+				type,
+				script,
+				checksum,
+				installed_by,
+				installed_on,
+				execution_time,
+				success )
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
+			st.quotedMetatable(),
+		),
+		rank, sql.NullString{String: sf.versionStr, Valid: !sf.repeatable},
+		sf.label, sf.migrationType(), sf.script, int32(sf.checksum),
+		m.userID, installedOn.UTC(), int32(executionTime/time.Millisecond), success,
+	)
+	return err
+}
+
+// logf writes a formatted message to the logger, if any.
+func (m *Migrator) logf(format string, args ...interface{}) {
+	if m.logger != nil {
+		// nolint: errcheck
+		m.logger.Output(3, fmt.Sprintf(format, args...))
+	}
+}
+
+func doTx(ctx context.Context, db ContextDB, txFunc func(*sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
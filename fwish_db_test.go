@@ -1,9 +1,16 @@
 package fwish_test
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/lib/pq"
 
 	"github.com/rez-go/fwish"
 	sqlsource "github.com/rez-go/fwish/sources/sql"
@@ -32,6 +39,74 @@ func init() {
 	}
 }
 
+// testSchemaID is the ID of the schema of the test sources.
+const testSchemaID = "372ce18d-02a2-4cb1-828a-bb470f02fe6e"
+
+// writeTestSource writes the files of a SQL source into a temporary
+// directory and returns the directory. The fwish.yaml with testSchemaID
+// is written unless it's in the files.
+func writeTestSource(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if _, ok := files["fwish.yaml"]; !ok {
+		writeTestFile(t, dir, "fwish.yaml", "id: "+testSchemaID+"\n")
+	}
+	for name, content := range files {
+		writeTestFile(t, dir, name, content)
+	}
+	return dir
+}
+
+// writeTestFile writes a file of the SQL source in dir, e.g., to add or
+// modify a migration between the runs.
+func writeTestFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestMigrator creates a migrator with the SQL source in dir. The
+// source is loaded every time so that the changes made with
+// writeTestFile are picked up.
+func newTestMigrator(t *testing.T, dir string) *fwish.Migrator {
+	t.Helper()
+	mg, err := fwish.NewMigrator(testSchemaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := sqlsource.LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mg.AddSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mg
+}
+
+// openTestDB opens the test DB with the test schema dropped. The schema
+// is dropped again, and the DB is closed, when the test finishes.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("postgres", testDBDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropSchema := `DROP SCHEMA IF EXISTS ` + testDBSchemaName + ` CASCADE`
+	t.Cleanup(func() {
+		db.Exec(dropSchema)
+		db.Close()
+	})
+	_, err = db.Exec(dropSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
 // Very basic functional test
 func TestBasic(t *testing.T) {
 	mg, err := fwish.NewMigrator("372ce18d-02a2-4cb1-828a-bb470f02fe6e")
@@ -133,3 +208,1333 @@ func TestBadRank(t *testing.T) {
 	//TODO:
 	// apply migrations, alter or delete a row from meta table, then validate
 }
+
+func TestStatus(t *testing.T) {
+	mg, err := fwish.NewMigrator("372ce18d-02a2-4cb1-828a-bb470f02fe6e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := sqlsource.LoadDir("./test-data/basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mg.AddSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := openTestDB(t)
+
+	status, err := mg.Status(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Initialized {
+		t.Fatal("schema should not be initialized")
+	}
+	for _, ms := range status.Migrations {
+		if ms.State != fwish.MigrationStatePending {
+			t.Fatalf("%s: pending expected, got %s", ms.Version, ms.State)
+		}
+	}
+
+	_, err = mg.Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err = mg.Status(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.CurrentVersion != "10" {
+		t.Fatalf("version 10 expected, got %q", status.CurrentVersion)
+	}
+	for _, ms := range status.Migrations {
+		if ms.State != fwish.MigrationStateApplied {
+			t.Fatalf("%s: applied expected, got %s", ms.Version, ms.State)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	mg, err := fwish.NewMigrator("372ce18d-02a2-4cb1-828a-bb470f02fe6e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := sqlsource.LoadDir("./test-data/basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mg.AddSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := openTestDB(t)
+
+	_, err = mg.Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := mg.Validate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("no problems expected, got %v", problems)
+	}
+
+	// Tamper two rows
+	_, err = db.Exec(`UPDATE ` + testDBSchemaName + `.schema_version
+		SET checksum = checksum + 1 WHERE installed_rank IN (1, 2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err = mg.Validate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("2 problems expected, got %v", problems)
+	}
+	for _, p := range problems {
+		if p.Kind != fwish.ValidationProblemChecksumMismatch {
+			t.Errorf("checksum mismatch expected, got %s", p.Kind)
+		}
+	}
+
+	_, err = mg.Migrate(db, testDBSchemaName)
+	if err == nil {
+		t.Fatal("unexpected nil error")
+	}
+}
+
+func TestRepair(t *testing.T) {
+	mg, err := fwish.NewMigrator("372ce18d-02a2-4cb1-828a-bb470f02fe6e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := sqlsource.LoadDir("./test-data/basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mg.AddSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := openTestDB(t)
+
+	n, err := mg.Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(`UPDATE ` + testDBSchemaName + `.schema_version
+		SET checksum = checksum + 1 WHERE installed_rank = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO `+testDBSchemaName+`.schema_version
+		VALUES ($1, '11', 'Broken', 'SQL', 'V11__Broken.sql', 0, 'test', now(), 0, false)`,
+		n+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mg.Migrate(db, testDBSchemaName)
+	if !errors.Is(err, fwish.ErrSchemaHasFailedMigration) {
+		t.Fatalf("ErrSchemaHasFailedMigration expected, got %v", err)
+	}
+
+	planned, err := mg.PlanRepair(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(planned) != 2 {
+		t.Fatalf("2 actions expected, got %v", planned)
+	}
+
+	// The history changed after the plan
+	_, err = db.Exec(`UPDATE ` + testDBSchemaName + `.schema_version
+		SET checksum = checksum + 1 WHERE installed_rank = 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mg.RepairPlanned(db, testDBSchemaName, planned)
+	if !errors.Is(err, fwish.ErrRepairPlanChanged) {
+		t.Fatalf("ErrRepairPlanChanged expected, got %v", err)
+	}
+
+	planned, err = mg.PlanRepair(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions, err := mg.RepairPlanned(db, testDBSchemaName, planned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 3 {
+		t.Fatalf("3 actions expected, got %v", actions)
+	}
+
+	problems, err := mg.Validate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("no problems expected, got %v", problems)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	mg, err := fwish.NewMigrator("372ce18d-02a2-4cb1-828a-bb470f02fe6e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := sqlsource.LoadDir("./test-data/basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mg.AddSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := openTestDB(t)
+
+	// A schema created before fwish was adopted
+	_, err = db.Exec(`CREATE SCHEMA ` + testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE ` + testDBSchemaName + `.legacy (id int)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mg.Migrate(db, testDBSchemaName)
+	if err != fwish.ErrSchemaNotEmpty {
+		t.Fatalf("ErrSchemaNotEmpty expected, got %v", err)
+	}
+
+	err = mg.Baseline(db, testDBSchemaName, "3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := mg.Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("4 expected, got %d", n)
+	}
+
+	err = mg.Baseline(db, testDBSchemaName, "3", "")
+	if err != fwish.ErrSchemaAlreadyMigrated {
+		t.Fatalf("ErrSchemaAlreadyMigrated expected, got %v", err)
+	}
+}
+
+func TestClean(t *testing.T) {
+	mg, err := fwish.NewMigrator("372ce18d-02a2-4cb1-828a-bb470f02fe6e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := sqlsource.LoadDir("./test-data/basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mg.AddSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := openTestDB(t)
+
+	_, err = mg.Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mg.Clean(db, testDBSchemaName)
+	if err != fwish.ErrCleanDisabled {
+		t.Fatalf("ErrCleanDisabled expected, got %v", err)
+	}
+
+	mg.WithAllowClean(true)
+	err = mg.Clean(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The schema is kept but it's empty so that we could migrate again
+	n, err := mg.Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Fatal("migrations expected")
+	}
+}
+
+func TestRepeatable(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql":        "CREATE TABLE person (id int, name text);\n",
+		"R__Person_names.sql": "CREATE OR REPLACE VIEW person_names AS SELECT name FROM person;\n",
+	})
+
+	db := openTestDB(t)
+
+	n, err := newTestMigrator(t, dir).Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("2 expected, got %d", n)
+	}
+
+	// Unchanged repeatable migrations are not applied again
+	n, err = newTestMigrator(t, dir).Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("0 expected, got %d", n)
+	}
+
+	writeTestFile(t, dir, "R__Person_names.sql", "CREATE OR REPLACE VIEW person_names AS SELECT id, name FROM person;\n")
+
+	mg := newTestMigrator(t, dir)
+	status, err := mg.Status(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var numOutdated int
+	for _, ms := range status.Migrations {
+		if ms.State == fwish.MigrationStateOutdated {
+			numOutdated++
+		}
+	}
+	if numOutdated != 1 {
+		t.Fatalf("1 outdated expected, got %d", numOutdated)
+	}
+
+	n, err = mg.Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("1 expected, got %d", n)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql":       "CREATE TABLE person (id int, name text);\n",
+		"U1__Init.sql":       "DROP TABLE person;\n",
+		"V2__Add_people.sql": "INSERT INTO person VALUES (1, 'Axel');\n",
+		"U2__Add_people.sql": "DELETE FROM person WHERE id = 1;\n",
+		"V3__Add_column.sql": "ALTER TABLE person ADD COLUMN email text;\n",
+		"U3__Add_column.sql": "ALTER TABLE person DROP COLUMN email;\n",
+	})
+
+	mg := newTestMigrator(t, dir)
+
+	db := openTestDB(t)
+
+	n, err := mg.Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("3 expected, got %d", n)
+	}
+
+	// Without target, only the latest is reverted
+	n, err = mg.Undo(db, testDBSchemaName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("1 expected, got %d", n)
+	}
+
+	n, err = mg.Undo(db, testDBSchemaName, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("1 expected, got %d", n)
+	}
+
+	status, err := mg.Status(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.CurrentVersion != "1" {
+		t.Fatalf("version 1 expected, got %q", status.CurrentVersion)
+	}
+
+	// The reverted migrations are applied again
+	n, err = mg.Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("2 expected, got %d", n)
+	}
+
+	// The latest of the re-applied migrations is reverted
+	n, err = mg.Undo(db, testDBSchemaName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("1 expected, got %d", n)
+	}
+
+	status, err = mg.Status(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		rank    int32
+		version string
+		typ     string
+		state   fwish.MigrationState
+	}{
+		{1, "1", fwish.MigrationTypeSQL, fwish.MigrationStateApplied},
+		{2, "2", fwish.MigrationTypeSQL, fwish.MigrationStateUndone},
+		{3, "3", fwish.MigrationTypeSQL, fwish.MigrationStateUndone},
+		{4, "3", fwish.MigrationTypeUndoSQL, fwish.MigrationStateApplied},
+		{5, "2", fwish.MigrationTypeUndoSQL, fwish.MigrationStateApplied},
+		// Not out of order as version 3 had been undone
+		{6, "2", fwish.MigrationTypeSQL, fwish.MigrationStateApplied},
+		{7, "3", fwish.MigrationTypeSQL, fwish.MigrationStateUndone},
+		{8, "3", fwish.MigrationTypeUndoSQL, fwish.MigrationStateApplied},
+		{0, "3", fwish.MigrationTypeSQL, fwish.MigrationStatePending},
+	}
+	if len(status.Migrations) != len(expected) {
+		t.Fatalf("%d migrations expected, got %+v", len(expected), status.Migrations)
+	}
+	for i, e := range expected {
+		ms := status.Migrations[i]
+		if ms.InstalledRank != e.rank || ms.Version != e.version ||
+			ms.Type != e.typ || ms.State != e.state {
+			t.Errorf("#%d: %+v expected, got %+v", i, e, ms)
+		}
+	}
+	if status.CurrentVersion != "2" {
+		t.Fatalf("version 2 expected, got %q", status.CurrentVersion)
+	}
+
+	// Then the re-applied version 2, not version 1
+	n, err = mg.Undo(db, testDBSchemaName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("1 expected, got %d", n)
+	}
+	var exists bool
+	err = db.QueryRow(`SELECT to_regclass($1) IS NOT NULL`,
+		testDBSchemaName+".person").Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("table person should not have been dropped")
+	}
+	status, err = mg.Status(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.CurrentVersion != "1" {
+		t.Fatalf("version 1 expected, got %q", status.CurrentVersion)
+	}
+}
+
+func TestMigrateTarget(t *testing.T) {
+	mg, err := fwish.NewMigrator("372ce18d-02a2-4cb1-828a-bb470f02fe6e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := sqlsource.LoadDir("./test-data/basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mg.AddSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := openTestDB(t)
+
+	cases := []struct {
+		target string
+		num    int
+	}{
+		{"3", 4},
+		{fwish.MigrateTargetNext, 1},
+		{fwish.MigrateTargetCurrent, 0},
+		{"3.1", 0},
+		{fwish.MigrateTargetLatest, 3},
+	}
+
+	for i, c := range cases {
+		n, err := mg.MigrateWithOptions(db, testDBSchemaName,
+			fwish.MigrateOptions{Target: c.target})
+		if err != nil {
+			t.Fatalf("#%d: %v", i+1, err)
+		}
+		if n != c.num {
+			t.Fatalf("#%d: %d expected, got %d", i+1, c.num, n)
+		}
+	}
+}
+
+func TestOutOfOrder(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql":      "CREATE TABLE person (id int, name text);\n",
+		"V3__Add_email.sql": "ALTER TABLE person ADD COLUMN email text;\n",
+	})
+
+	db := openTestDB(t)
+
+	_, err := newTestMigrator(t, dir).Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A hotfix from another branch
+	writeTestFile(t, dir, "V2__Add_phone.sql", "ALTER TABLE person ADD COLUMN phone text;\n")
+
+	_, err = newTestMigrator(t, dir).Migrate(db, testDBSchemaName)
+	if err == nil {
+		t.Fatal("unexpected nil error")
+	}
+
+	mg := newTestMigrator(t, dir).WithOutOfOrder(true)
+	n, err := mg.Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("1 expected, got %d", n)
+	}
+
+	status, err := mg.Status(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ms := range status.Migrations {
+		if ms.Version == "2" && ms.State != fwish.MigrationStateOutOfOrder {
+			t.Fatalf("out-of-order expected, got %s", ms.State)
+		}
+	}
+	if status.CurrentVersion != "3" {
+		t.Fatalf("version 3 expected, got %q", status.CurrentVersion)
+	}
+}
+
+func TestConcurrentMigrate(t *testing.T) {
+	db := openTestDB(t)
+
+	// Simulate multiple instances of an application migrating at startup
+	const numInstances = 3
+	results := make(chan int, numInstances)
+	errs := make(chan error, numInstances)
+	for i := 0; i < numInstances; i++ {
+		go func() {
+			mg, err := fwish.NewMigrator("372ce18d-02a2-4cb1-828a-bb470f02fe6e")
+			if err != nil {
+				errs <- err
+				return
+			}
+			src, err := sqlsource.LoadDir("./test-data/basic")
+			if err != nil {
+				errs <- err
+				return
+			}
+			err = mg.AddSource(src)
+			if err != nil {
+				errs <- err
+				return
+			}
+			n, err := mg.Migrate(db, testDBSchemaName)
+			if err != nil {
+				errs <- err
+				return
+			}
+			results <- n
+		}()
+	}
+
+	var total int
+	for i := 0; i < numInstances; i++ {
+		select {
+		case n := <-results:
+			total += n
+		case err := <-errs:
+			t.Fatal(err)
+		}
+	}
+	if total != 8 {
+		t.Fatalf("8 expected, got %d", total)
+	}
+}
+
+func TestMigrateContext(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql":  "CREATE TABLE person (id int, name text);\n",
+		"V2__Stuck.sql": "SELECT pg_sleep(30);\n",
+	})
+
+	mg := newTestMigrator(t, dir)
+
+	db := openTestDB(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	tStart := time.Now()
+	_, err := mg.MigrateContext(ctx, db, testDBSchemaName, fwish.MigrateOptions{})
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	if d := time.Since(tStart); d > 10*time.Second {
+		t.Fatalf("migration was not cancelled (took %s)", d)
+	}
+
+	status, err := mg.Status(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.CurrentVersion != "1" {
+		t.Fatalf("version 1 expected, got %q", status.CurrentVersion)
+	}
+}
+
+func TestMigrateRollback(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql":   "CREATE TABLE person (id int, name text);\n",
+		"V2__Broken.sql": "CREATE TABLE pet (id int);\nSELECT 1/0;\n",
+	})
+
+	mg := newTestMigrator(t, dir)
+
+	db := openTestDB(t)
+
+	_, err := mg.Migrate(db, testDBSchemaName)
+	if err == nil {
+		t.Fatal("error expected")
+	}
+
+	// The failed migration must leave neither its effects nor its
+	// history row.
+	var exists bool
+	err = db.QueryRow(`SELECT to_regclass($1) IS NOT NULL`,
+		testDBSchemaName+".pet").Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("table pet should have been rolled back")
+	}
+	status, err := mg.Status(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ms := range status.Migrations {
+		if ms.Version == "2" && ms.State != fwish.MigrationStatePending {
+			t.Fatalf("migration 2 should be pending, got %q", ms.State)
+		}
+	}
+	if status.CurrentVersion != "1" {
+		t.Fatalf("version 1 expected, got %q", status.CurrentVersion)
+	}
+}
+
+func TestMigrateGroup(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql":   "CREATE TABLE person (id int, name text);\n",
+		"V2__Pet.sql":    "CREATE TABLE pet (id int);\n",
+		"V3__Broken.sql": "SELECT 1/0;\n",
+	})
+
+	db := openTestDB(t)
+
+	opts := fwish.MigrateOptions{Group: true}
+
+	_, err := newTestMigrator(t, dir).MigrateWithOptions(db, testDBSchemaName, opts)
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	status, err := newTestMigrator(t, dir).Status(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.CurrentVersion != "" {
+		t.Fatalf("no version expected, got %q", status.CurrentVersion)
+	}
+
+	writeTestFile(t, dir, "V3__Broken.sql", "SELECT 1;\n")
+	n, err := newTestMigrator(t, dir).MigrateWithOptions(db, testDBSchemaName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("3 expected, got %d", n)
+	}
+}
+
+func TestMigrateNoTransaction(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql": "CREATE TABLE person (id int, name text);\n",
+		"V2__Index.sql": "-- fwish:no-transaction\n" +
+			"CREATE INDEX CONCURRENTLY person_name_idx ON person (name);\n",
+	})
+
+	db := openTestDB(t)
+
+	var called bool
+	_, err := newTestMigrator(t, dir).
+		OnBeforeMigrate(func(context.Context, fwish.Executor, fwish.CallbackInfo) error {
+			called = true
+			return nil
+		}).
+		MigrateWithOptions(db, testDBSchemaName, fwish.MigrateOptions{Group: true})
+	if !errors.Is(err, fwish.ErrGroupNoTransaction) {
+		t.Fatalf("ErrGroupNoTransaction expected, got %v", err)
+	}
+	// Rejected before anything has been done
+	if called {
+		t.Fatal("beforeMigrate callback should not have been called")
+	}
+	var exists bool
+	err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_namespace WHERE nspname = $1)`,
+		testDBSchemaName).Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("the schema should not have been created")
+	}
+
+	n, err := newTestMigrator(t, dir).Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("2 expected, got %d", n)
+	}
+}
+
+func TestMigrateStatements(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql": "CREATE TABLE person (id int, name text);\n" +
+			"CREATE FUNCTION person_count() RETURNS bigint AS $$\n" +
+			"BEGIN\n  RETURN (SELECT count(*) FROM person);\nEND;\n$$ LANGUAGE plpgsql;\n" +
+			"COPY person (id, name) FROM stdin;\n" +
+			"1\tAlice\\tthe first\n" +
+			"2\t\\N\n" +
+			"\\.\n",
+		"V2__More_people.sql": "-- fwish:no-transaction\n" +
+			"COPY person (id, name) FROM stdin;\n" +
+			"3\tCharlie\n" +
+			"\\.\n",
+	})
+
+	mg := newTestMigrator(t, dir)
+
+	db := openTestDB(t)
+
+	_, err := mg.Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var name string
+	err = db.QueryRow(`SELECT name FROM ` + testDBSchemaName + `.person WHERE id = 1`).Scan(&name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Alice\tthe first" {
+		t.Fatalf("unexpected name %q", name)
+	}
+	var count int
+	err = db.QueryRow(`SELECT ` + testDBSchemaName + `.person_count()`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("3 expected, got %d", count)
+	}
+
+	// Through the source's legacy ExecuteMigration
+	writeTestFile(t, dir, "V3__Even_more_people.sql",
+		"COPY "+testDBSchemaName+".person (id, name) FROM stdin;\n4\tDave\n\\.\n")
+	src, err := sqlsource.LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := src.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mi := range migrations {
+		if mi.Name != "V3__Even_more_people" {
+			continue
+		}
+		if err = src.ExecuteMigration(db, mi); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.QueryRow(`SELECT name FROM ` + testDBSchemaName + `.person WHERE id = 4`).Scan(&name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Dave" {
+		t.Fatalf("unexpected name %q", name)
+	}
+}
+
+func TestMigrationErrorPosition(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql": "CREATE TABLE person (id int, name text);\n",
+		"V2__Add_people.sql": "-- People\n" +
+			"INSERT INTO person VALUES (1, 'Élodie');\n" +
+			"INSERT INTO person\n" +
+			"  VALUES (2, 'Bob') RETURNIN id;\n",
+	})
+
+	mg := newTestMigrator(t, dir)
+
+	db := openTestDB(t)
+
+	_, err := mg.Migrate(db, testDBSchemaName)
+	var me *fwish.MigrationError
+	if !errors.As(err, &me) {
+		t.Fatalf("MigrationError expected, got %v", err)
+	}
+	if me.Version != "2" || me.Script != "V2__Add_people.sql" {
+		t.Fatalf("unexpected migration %q %q", me.Version, me.Script)
+	}
+	if me.StatementIndex != 2 {
+		t.Fatalf("statement 2 expected, got %d", me.StatementIndex)
+	}
+	if me.Line != 4 || me.Column != 21 {
+		t.Fatalf("4:21 expected, got %d:%d", me.Line, me.Column)
+	}
+	if !strings.HasPrefix(me.Error(), "V2__Add_people.sql:4:21: ") {
+		t.Fatalf("unexpected message %q", me.Error())
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	mg, err := fwish.NewMigrator("372ce18d-02a2-4cb1-828a-bb470f02fe6e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := sqlsource.LoadDir("./test-data/basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mg.AddSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := openTestDB(t)
+
+	n, err := mg.Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var checksum int32
+	err = db.QueryRow(`SELECT checksum FROM ` + testDBSchemaName + `.schema_version
+		WHERE installed_rank = 1`).Scan(&checksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`UPDATE ` + testDBSchemaName + `.schema_version
+		SET checksum = checksum + 1 WHERE installed_rank = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mg.Migrate(db, testDBSchemaName)
+	var checksumErr *fwish.ChecksumMismatchError
+	if !errors.As(err, &checksumErr) {
+		t.Fatalf("ChecksumMismatchError expected, got %v", err)
+	}
+	if int32(checksumErr.Expected) != checksum || int32(checksumErr.Actual) != checksum+1 {
+		t.Fatalf("unexpected checksums %d and %d", checksumErr.Expected, checksumErr.Actual)
+	}
+
+	_, err = mg.Repair(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO `+testDBSchemaName+`.schema_version
+		VALUES ($1, '99', 'Future', 'SQL', 'V99__Future.sql', 0, 'test', now(), 0, true)`,
+		n+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mg.Migrate(db, testDBSchemaName)
+	var unknownErr *fwish.UnknownAppliedMigrationError
+	if !errors.As(err, &unknownErr) {
+		t.Fatalf("UnknownAppliedMigrationError expected, got %v", err)
+	}
+	if unknownErr.Version != "99" {
+		t.Fatalf("version 99 expected, got %q", unknownErr.Version)
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"fwish.yaml": "id: 372ce18d-02a2-4cb1-828a-bb470f02fe6e\n" +
+			"placeholders:\n  owner: nobody\n  tableName: person\n",
+		"V1__Init.sql": "CREATE TABLE ${tableName} (id int, note text);\n" +
+			"INSERT INTO ${fwish:defaultSchema}.${tableName} VALUES (1, '${owner}');\n",
+		"V2__Unknown.sql": "SELECT 1;\nSELECT '${nope}';\n",
+	})
+
+	db := openTestDB(t)
+
+	mg := newTestMigrator(t, dir).WithPlaceholders(map[string]string{"owner": "Alice"})
+	_, err := mg.MigrateWithOptions(db, testDBSchemaName, fwish.MigrateOptions{Target: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var note string
+	err = db.QueryRow(`SELECT note FROM ` + testDBSchemaName + `.person WHERE id = 1`).Scan(&note)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note != "Alice" {
+		t.Fatalf("Alice expected, got %q", note)
+	}
+
+	_, err = mg.Migrate(db, testDBSchemaName)
+	var me *fwish.MigrationError
+	if !errors.As(err, &me) {
+		t.Fatalf("MigrationError expected, got %v", err)
+	}
+	if me.Line != 2 || me.Column != 9 || !strings.Contains(me.Error(), "${nope}") {
+		t.Fatalf("unexpected error %v", me)
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql":           "CREATE TABLE person (id int, name text);\n",
+		"V2__Add_people.sql":     "INSERT INTO person VALUES (1, 'Alice');\n",
+		"beforeMigrate.sql":      "CREATE TABLE IF NOT EXISTS audit (event text);\n",
+		"afterEachMigrate.sql":   "INSERT INTO audit VALUES ('${fwish:filename}');\n",
+		"afterMigrate__Done.sql": "INSERT INTO audit VALUES ('done');\n",
+	})
+
+	var events []string
+	record := func(ctx context.Context, db fwish.Executor, info fwish.CallbackInfo) error {
+		event := string(info.Event)
+		if info.Migration != nil {
+			event += " " + info.Migration.Name
+		}
+		events = append(events, event)
+		return nil
+	}
+
+	mg := newTestMigrator(t, dir)
+	mg.OnBeforeMigrate(record).
+		OnBeforeEach(record).
+		OnAfterEach(record).
+		OnAfterMigrate(record).
+		OnError(record)
+
+	db := openTestDB(t)
+
+	_, err := mg.Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"beforeMigrate",
+		"beforeEachMigrate V1__Init",
+		"afterEachMigrate V1__Init",
+		"beforeEachMigrate V2__Add_people",
+		"afterEachMigrate V2__Add_people",
+		"afterMigrate",
+	}
+	if strings.Join(events, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %q, got %q", expected, events)
+	}
+
+	rows, err := db.Query(`SELECT event FROM ` + testDBSchemaName + `.audit`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var audit []string
+	for rows.Next() {
+		var event string
+		if err = rows.Scan(&event); err != nil {
+			t.Fatal(err)
+		}
+		audit = append(audit, event)
+	}
+	expected = []string{"V1__Init.sql", "V2__Add_people.sql", "done"}
+	if strings.Join(audit, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %q, got %q", expected, audit)
+	}
+
+	// A failed afterMigrate callback doesn't undo the migrations
+	writeTestFile(t, dir, "V3__More_people.sql", "INSERT INTO person VALUES (2, 'Bob');\n")
+	events = nil
+	cbErr := errors.New("boom")
+	n, err := newTestMigrator(t, dir).
+		OnAfterMigrate(func(context.Context, fwish.Executor, fwish.CallbackInfo) error {
+			return cbErr
+		}).
+		OnError(record).
+		Migrate(db, testDBSchemaName)
+	if !errors.Is(err, cbErr) {
+		t.Fatalf("callback error expected, got %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("1 migration expected, got %d", n)
+	}
+	if strings.Join(events, ",") != "afterMigrateError" {
+		t.Fatalf("afterMigrateError expected, got %q", events)
+	}
+}
+
+func TestFlywayMode(t *testing.T) {
+	v1 := "CREATE TABLE person (id int);\n"
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql": v1,
+		"V2__Name.sql": "ALTER TABLE person ADD COLUMN name text;\n",
+	})
+
+	loadMigrator := func() *fwish.Migrator {
+		return newTestMigrator(t, dir).WithFlywayMode(true)
+	}
+
+	db := openTestDB(t)
+
+	// A fresh schema is recorded the way Flyway does
+	n, err := loadMigrator().MigrateWithOptions(db, testDBSchemaName, fwish.MigrateOptions{Target: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("1 migration expected, got %d", n)
+	}
+	var typ string
+	err = db.QueryRow(`SELECT type FROM ` + testDBSchemaName +
+		`.flyway_schema_history WHERE installed_rank = 1`).Scan(&typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != fwish.MigrationTypeSchema {
+		t.Fatalf("SCHEMA row expected at rank 1, got %q", typ)
+	}
+
+	n, err = loadMigrator().Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("1 migration expected, got %d", n)
+	}
+	status, err := loadMigrator().Status(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.CurrentVersion != "2" || len(status.Migrations) != 3 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if ms := status.Migrations[2]; ms.InstalledRank != 3 || ms.State != fwish.MigrationStateApplied {
+		t.Fatalf("unexpected migration status %+v", ms)
+	}
+
+	// A schema created by Flyway in a table with a custom name
+	checksum, err := sqlsource.Checksum(strings.NewReader(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`DROP SCHEMA ` + testDBSchemaName + ` CASCADE`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE SCHEMA ` + testDBSchemaName + `;
+		CREATE TABLE ` + testDBSchemaName + `.history (
+			installed_rank integer NOT NULL PRIMARY KEY,
+			version character varying(50),
+			description character varying(200) NOT NULL,
+			type character varying(20) NOT NULL,
+			script character varying(1000) NOT NULL,
+			checksum integer,
+			installed_by character varying(100) NOT NULL,
+			installed_on timestamp without time zone NOT NULL DEFAULT now(),
+			execution_time integer NOT NULL,
+			success boolean NOT NULL);
+		CREATE TABLE ` + testDBSchemaName + `.person (id int)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rank 2 was a failed migration deleted by flyway repair
+	_, err = db.Exec(`INSERT INTO `+testDBSchemaName+`.history VALUES
+			(1, NULL, '<< Flyway Schema Creation >>', 'SCHEMA', '"`+testDBSchemaName+`"', NULL, 'flyway', now(), 0, true),
+			(3, '1', 'Init', 'SQL', 'V1__Init.sql', $1, 'flyway', now(), 5, true)`,
+		int32(checksum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mg := loadMigrator().WithMetatableName("history")
+	problems, err := mg.Validate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("no problems expected, got %v", problems)
+	}
+	n, err = mg.Migrate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("1 migration expected, got %d", n)
+	}
+	var rank int32
+	err = db.QueryRow(`SELECT installed_rank FROM ` + testDBSchemaName +
+		`.history WHERE version = '2'`).Scan(&rank)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rank != 4 {
+		t.Fatalf("rank 4 expected, got %d", rank)
+	}
+
+	// Without the Flyway mode, the gap is a problem
+	problems, err = newTestMigrator(t, dir).WithMetatableName("history").Validate(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) == 0 || problems[0].Kind != fwish.ValidationProblemInsequentialRank {
+		t.Fatalf("insequential rank expected, got %v", problems)
+	}
+}
+
+func TestQuotedNames(t *testing.T) {
+	const schemaName = `Select "Me"`
+
+	db := openTestDB(t)
+	dropSchema := `DROP SCHEMA IF EXISTS ` + pq.QuoteIdentifier(schemaName) + ` CASCADE`
+	_, err := db.Exec(dropSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(dropSchema)
+
+	mg, err := fwish.NewMigrator("372ce18d-02a2-4cb1-828a-bb470f02fe6e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := sqlsource.LoadDir("./test-data/basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mg.AddSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mg.WithMetatableName("Table")
+
+	n, err := mg.Migrate(db, schemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Fatal("migrations expected")
+	}
+	var count int
+	err = db.QueryRow(`SELECT count(*) FROM ` + pq.QuoteIdentifier(schemaName) + `."Table"`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Including the meta row
+	if count != n+1 {
+		t.Fatalf("%d rows expected, got %d", n+1, count)
+	}
+
+	status, err := mg.Status(db, schemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.SchemaName != schemaName || len(status.Migrations) != n {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestMigrateSession(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql": "CREATE TABLE person (id int, name text);\n",
+		"V2__Session.sql": "-- fwish:no-transaction\n" +
+			"SET statement_timeout = '1234ms';\n" +
+			"INSERT INTO person VALUES (1, current_setting('statement_timeout'));\n",
+		"V3__Check.sql": "INSERT INTO person VALUES (2, current_setting('statement_timeout'));\n",
+	})
+
+	db := openTestDB(t)
+	// Everything must go through the pinned connection
+	db.SetMaxOpenConns(1)
+
+	var searchPath, timeout string
+	err := db.QueryRow(`SHOW search_path`).Scan(&searchPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRow(`SHOW statement_timeout`).Scan(&timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	n, err := newTestMigrator(t, dir).MigrateContext(ctx, db, testDBSchemaName,
+		fwish.MigrateOptions{Target: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("2 migrations expected, got %d", n)
+	}
+
+	// The session settings don't leak into the pool
+	var s string
+	err = db.QueryRow(`SHOW search_path`).Scan(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != searchPath {
+		t.Fatalf("search_path %q expected, got %q", searchPath, s)
+	}
+	err = db.QueryRow(`SHOW statement_timeout`).Scan(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != timeout {
+		t.Fatalf("statement_timeout %q expected, got %q", timeout, s)
+	}
+
+	// A connection provided by the caller is used as is
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, `SET statement_timeout = '4321ms'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = newTestMigrator(t, dir).MigrateContext(ctx, conn, testDBSchemaName, fwish.MigrateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("1 migration expected, got %d", n)
+	}
+	err = conn.QueryRowContext(ctx, `SHOW search_path`).Scan(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != searchPath {
+		t.Fatalf("search_path %q expected, got %q", searchPath, s)
+	}
+	err = conn.QueryRowContext(ctx,
+		`SELECT name FROM `+testDBSchemaName+`.person WHERE id = 2`).Scan(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "4321ms" {
+		t.Fatalf("the connection's statement_timeout expected, got %q", s)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql": "CREATE TABLE person (id int, name text);\n",
+		"V2__Index.sql": "-- fwish:no-transaction\n" +
+			"CREATE INDEX CONCURRENTLY person_name_idx ON ${fwish:defaultSchema}.person (name);\n",
+		"R__View.sql":          "CREATE OR REPLACE VIEW people AS SELECT * FROM person;\n",
+		"beforeMigrate.sql":    "SET lock_timeout = '10s';\n",
+		"afterEachMigrate.sql": "SELECT '${fwish:filename}';\n",
+		"afterMigrate.sql":     "ANALYZE;\n",
+	})
+
+	db := openTestDB(t)
+
+	plan, err := newTestMigrator(t, dir).Plan(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Initialize || len(plan.Migrations) != 3 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	var scripts []string
+	for _, pm := range plan.Migrations {
+		scripts = append(scripts, pm.Script)
+	}
+	if strings.Join(scripts, ",") != "V1__Init.sql,V2__Index.sql,R__View.sql" {
+		t.Fatalf("unexpected order %v", scripts)
+	}
+	pm := plan.Migrations[1]
+	if pm.Version != "2" || !pm.NoTransaction ||
+		!strings.Contains(pm.SQL, "ON "+testDBSchemaName+".person") {
+		t.Fatalf("unexpected planned migration %+v", pm)
+	}
+	if plan.BeforeMigrateSQL != "SET lock_timeout = '10s';\n" ||
+		plan.AfterMigrateSQL != "ANALYZE;\n" {
+		t.Fatalf("unexpected callbacks %q %q", plan.BeforeMigrateSQL, plan.AfterMigrateSQL)
+	}
+	if pm.BeforeEachSQL != "" || pm.AfterEachSQL != "SELECT 'V2__Index.sql';\n" {
+		t.Fatalf("unexpected callbacks %q %q", pm.BeforeEachSQL, pm.AfterEachSQL)
+	}
+
+	// Nothing has been changed
+	status, err := newTestMigrator(t, dir).Status(db, testDBSchemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Initialized {
+		t.Fatal("the schema should not have been initialized")
+	}
+
+	_, err = newTestMigrator(t, dir).PlanWithOptions(db, testDBSchemaName, fwish.MigrateOptions{Group: true})
+	if !errors.Is(err, fwish.ErrGroupNoTransaction) {
+		t.Fatalf("ErrGroupNoTransaction expected, got %v", err)
+	}
+
+	_, err = newTestMigrator(t, dir).MigrateWithOptions(db, testDBSchemaName, fwish.MigrateOptions{Target: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err = newTestMigrator(t, dir).PlanWithOptions(db, testDBSchemaName, fwish.MigrateOptions{Target: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Initialize || plan.CurrentVersion != "1" || len(plan.Migrations) != 2 ||
+		plan.Migrations[0].Version != "2" || !plan.Migrations[1].Repeatable {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+}
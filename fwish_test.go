@@ -1,6 +1,7 @@
 package fwish_test
 
 import (
+	"context"
 	"errors"
 	"strings"
 	"testing"
@@ -72,3 +73,96 @@ func TestSchemaIDMismatchSourceProgram(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+type sourceRepeatableConflict struct {
+}
+
+func (s *sourceRepeatableConflict) SchemaID() string   { return "" }
+func (s *sourceRepeatableConflict) SchemaName() string { return "" }
+
+func (s *sourceRepeatableConflict) Migrations() ([]fwish.MigrationInfo, error) {
+	return []fwish.MigrationInfo{
+		{Name: "V1__test"},
+		{Name: "R__Refresh_views"},
+		{Name: "R__Refresh_views_"},
+	}, nil
+}
+
+func (s *sourceRepeatableConflict) ExecuteMigration(db fwish.DB, migration fwish.MigrationInfo) error {
+	return errors.New("not implemented")
+}
+
+func TestRepeatableConflict(t *testing.T) {
+	mg, err := fwish.NewMigrator("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mg.AddSource(&sourceRepeatableConflict{})
+	if err == nil {
+		t.Fatal("unexpected nil error")
+	}
+	if !strings.Contains(err.Error(), `repeatable migration "Refresh views" conflict`) {
+		t.Fatal("wrong error message")
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	cases := []struct {
+		schemaName    string
+		metatableName string
+	}{
+		{strings.Repeat("s", 64), ""},
+		{"bad\x00name", ""},
+		{"public", strings.Repeat("t", 64)},
+		// The index name would be too long
+		{"public", strings.Repeat("t", 60)},
+	}
+	for _, c := range cases {
+		mg, err := fwish.NewMigrator("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mg.WithMetatableName(c.metatableName)
+		// The names are validated before the DB is used
+		_, err = mg.StatusContext(context.Background(), nil, c.schemaName)
+		if !errors.Is(err, fwish.ErrInvalidName) {
+			t.Errorf("%q.%q: ErrInvalidName expected, got %v", c.schemaName, c.metatableName, err)
+		}
+	}
+}
+
+func TestMigrationErrorFormat(t *testing.T) {
+	cause := errors.New("boom")
+	cases := []struct {
+		err      *fwish.MigrationError
+		expected string
+	}{
+		{&fwish.MigrationError{Script: "V2__Add_people.sql", Line: 14, Column: 7, Err: cause},
+			"V2__Add_people.sql:14:7: boom"},
+		{&fwish.MigrationError{Script: "V2__Add_people.sql", Line: 14, Err: cause},
+			"V2__Add_people.sql:14: boom"},
+		{&fwish.MigrationError{Script: "V2__Add_people.sql", Err: cause},
+			"V2__Add_people.sql: boom"},
+	}
+	for _, c := range cases {
+		if s := c.err.Error(); s != c.expected {
+			t.Errorf("expected %q, got %q", c.expected, s)
+		}
+		if !errors.Is(c.err, cause) {
+			t.Errorf("%q should wrap the cause", c.expected)
+		}
+	}
+}
+
+func TestConnRequired(t *testing.T) {
+	mg, err := fwish.NewMigrator("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Neither a sql.Conn nor a pool
+	var db struct{ fwish.ContextDB }
+	_, err = mg.MigrateContext(context.Background(), db, "", fwish.MigrateOptions{})
+	if !errors.Is(err, fwish.ErrConnRequired) {
+		t.Fatalf("ErrConnRequired expected, got %v", err)
+	}
+}
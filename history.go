@@ -0,0 +1,90 @@
+package fwish
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The values of the type column of the metadata table.
+const (
+	// MigrationTypeSQL is for migrations from SQL scripts.
+	MigrationTypeSQL = "SQL"
+	// MigrationTypeBaseline is for the row written by Baseline.
+	MigrationTypeBaseline = "BASELINE"
+	// MigrationTypeUndoSQL is for undo migrations from SQL scripts.
+	MigrationTypeUndoSQL = "UNDO_SQL"
+	// MigrationTypeSchema is for the row which records that the schema
+	// has been created, in the Flyway mode.
+	MigrationTypeSchema = "SCHEMA"
+)
+
+// SchemaCreationDescription is the description of the
+// MigrationTypeSchema row, the same as Flyway's.
+const SchemaCreationDescription = "<< Flyway Schema Creation >>"
+
+// historyRow holds a row of the metadata table.
+type historyRow struct {
+	installedRank int32
+	version       sql.NullString
+	description   string
+	typ           string
+	script        string
+	checksum      sql.NullInt32
+	installedBy   string
+	installedOn   time.Time
+	executionTime int32
+	success       bool
+}
+
+// readHistory loads all the rows of the metadata table ordered by their
+// installed_rank. It returns nil rows and no error if the metadata
+// table does not exist.
+func readHistory(ctx context.Context, st *state) ([]historyRow, error) {
+	rows, err := st.db.QueryContext(ctx, fmt.Sprintf(
+		`SELECT installed_rank, version, description, type, script,
+			checksum, installed_by, installed_on, execution_time, success
+		FROM %s ORDER BY installed_rank`,
+		st.quotedMetatable(),
+	))
+	if err != nil {
+		pqErr, ok := err.(*pq.Error)
+		if !ok {
+			return nil, err
+		}
+		// 42P01: undefined_table
+		if pqErr.Code != "42P01" ||
+			!strings.Contains(pqErr.Message, `"`+st.schemaName+`.`+st.metatableName+`"`) {
+			return nil, err
+		}
+		return nil, nil
+	}
+	defer rows.Close()
+
+	var hl []historyRow
+	for rows.Next() {
+		var r historyRow
+		err = rows.Scan(
+			&r.installedRank, &r.version, &r.description, &r.typ, &r.script,
+			&r.checksum, &r.installedBy, &r.installedOn, &r.executionTime,
+			&r.success)
+		if err != nil {
+			return nil, err
+		}
+		hl = append(hl, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Distinguish an existing but empty table from a missing one.
+	if hl == nil {
+		hl = []historyRow{}
+	}
+
+	return hl, nil
+}
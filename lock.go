@@ -0,0 +1,93 @@
+package fwish
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// The interval between attempts to acquire the lock.
+const lockRetryInterval = time.Second
+
+// LockTimeoutError is returned when the migration lock of a schema could
+// not be acquired within the lock timeout, i.e., another instance is
+// still migrating the schema.
+type LockTimeoutError struct {
+	SchemaName string
+	Timeout    time.Duration
+}
+
+func (e *LockTimeoutError) Error() string {
+	return fmt.Sprintf("fwish: unable to acquire the migration lock of schema %q within %s",
+		e.SchemaName, e.Timeout)
+}
+
+// WithLockTimeout sets how long the operations which modify the schema,
+// e.g., Migrate, will wait for other instances which are operating on
+// the same schema to finish. A *LockTimeoutError is returned when the
+// timeout is reached. Zero, which is the default, means wait
+// indefinitely.
+func (m *Migrator) WithLockTimeout(timeout time.Duration) *Migrator {
+	m.lockTimeout = timeout
+	return m
+}
+
+// lockKey returns the key of the advisory lock for the schema.
+func lockKey(st *state) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(st.schemaName))
+	h.Write([]byte{0})
+	h.Write([]byte(st.metatableName))
+	return int64(h.Sum64())
+}
+
+// acquireLock acquires a PostgreSQL advisory lock keyed on the schema so
+// that only one instance operates on the schema at a time. The lock is
+// held by the session of the connection pinned with pinConn, as the
+// connection is needed for the migrations' transactions; it's released
+// by the returned function, or when the connection is closed, e.g., if
+// the process died.
+func (m *Migrator) acquireLock(ctx context.Context, st *state) (release func(), err error) {
+	conn, ok := st.db.(*sql.Conn)
+	if !ok {
+		// This would be an internal error
+		return nil, ErrConnRequired
+	}
+
+	key := lockKey(st)
+	tStart := time.Now()
+	for attempt := 0; ; attempt++ {
+		var locked bool
+		err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&locked)
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			break
+		}
+		if m.lockTimeout > 0 && time.Since(tStart) >= m.lockTimeout {
+			return nil, &LockTimeoutError{SchemaName: st.schemaName, Timeout: m.lockTimeout}
+		}
+		if attempt == 0 {
+			m.logf("Waiting for another instance to finish operating on schema %q",
+				st.schemaName)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+
+	return func() {
+		// Release it even if the context has been cancelled
+		_, err := conn.ExecContext(context.WithoutCancel(ctx),
+			`SELECT pg_advisory_unlock($1)`, key)
+		if err != nil {
+			m.logf("Releasing the lock of schema %q returned error: %v",
+				st.schemaName, err)
+		}
+	}, nil
+}
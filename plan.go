@@ -0,0 +1,194 @@
+package fwish
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Plan lists the migrations which Migrate would execute.
+type Plan struct {
+	// SchemaName is the name of the database schema.
+	SchemaName string
+	// CurrentVersion is the highest version successfully applied to
+	// the schema.
+	CurrentVersion string
+	// Initialize is true if the metadata table would be created.
+	Initialize bool
+	// Group is true if the migrations would be executed in a single
+	// transaction. See MigrateOptions.
+	Group bool
+	// Migrations lists the migrations in the order they would be
+	// executed.
+	Migrations []PlannedMigration
+	// BeforeMigrateSQL and AfterMigrateSQL are the SQL of the
+	// beforeMigrate and afterMigrate callbacks, which would be executed
+	// before and after the migrations. They are empty if there's none
+	// or if the sources don't implement CallbackScriptSource. The Go
+	// callbacks are not included.
+	BeforeMigrateSQL string
+	AfterMigrateSQL  string
+}
+
+// PlannedMigration is a migration which Migrate would execute.
+type PlannedMigration struct {
+	// Version is empty for repeatable migrations.
+	Version     string
+	Description string
+	Script      string
+	Repeatable  bool
+	Checksum    uint32
+	// NoTransaction is true if the migration would be executed without
+	// a transaction.
+	NoTransaction bool
+	Timeout       time.Duration
+	// SQL is the script as it would be executed, e.g., with the
+	// placeholders replaced. It's empty if the source doesn't implement
+	// ScriptSource.
+	SQL string
+	// BeforeEachSQL and AfterEachSQL are the SQL of the
+	// beforeEachMigrate and afterEachMigrate callbacks, which would be
+	// executed before and after the migration within its transaction.
+	// See Plan.BeforeMigrateSQL.
+	BeforeEachSQL string
+	AfterEachSQL  string
+}
+
+// ScriptSource is an optional interface for migration sources which
+// could provide the SQL of their migrations so that it could be
+// reviewed before being executed.
+type ScriptSource interface {
+	MigrationSource
+	// MigrationScript returns the SQL of the migration as it would be
+	// executed by ExecuteMigrationContext.
+	MigrationScript(ctx context.Context, migration MigrationInfo) (string, error)
+}
+
+// CallbackScriptSource is an optional interface for callback sources
+// which could provide the SQL of their callbacks. See ScriptSource.
+type CallbackScriptSource interface {
+	CallbackSource
+	// CallbackScript returns the SQL of the callbacks for the event as
+	// it would be executed by ExecuteCallback.
+	CallbackScript(ctx context.Context, info CallbackInfo) (string, error)
+}
+
+// Plan validates the schema and returns the migrations which Migrate
+// would execute. It doesn't change anything in the DB. As the schema
+// is not locked, the plan might be outdated by the time it's executed
+// if there are other instances migrating the schema.
+//
+// The schemaName parameter has the same semantic as Migrate's.
+func (m *Migrator) Plan(db DB, schemaName string) (*Plan, error) {
+	return m.PlanContext(context.Background(), contextDB(db), schemaName, MigrateOptions{})
+}
+
+// PlanWithOptions is Plan for MigrateWithOptions.
+func (m *Migrator) PlanWithOptions(db DB, schemaName string, opts MigrateOptions) (*Plan, error) {
+	return m.PlanContext(context.Background(), contextDB(db), schemaName, opts)
+}
+
+// PlanContext is the context-aware variant of PlanWithOptions.
+func (m *Migrator) PlanContext(
+	ctx context.Context, db ContextDB, schemaName string, opts MigrateOptions,
+) (*Plan, error) {
+	st, err := m.newState(db, schemaName)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := m.validateDBSchema(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		SchemaName:     st.schemaName,
+		CurrentVersion: status.CurrentVersion,
+		Initialize:     st.installedRank == -1,
+		Group:          opts.Group,
+	}
+	if plan.Initialize {
+		empty, err := isSchemaEmpty(ctx, st)
+		if err != nil {
+			return nil, err
+		}
+		if !empty {
+			return nil, ErrSchemaNotEmpty
+		}
+	}
+
+	pending, err := m.targetMigrations(status, m.pendingMigrations(status), opts.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.BeforeMigrateSQL, err = m.callbackScript(ctx, st, CallbackBeforeMigrate, nil, CallbackInfo{})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range pending {
+		sf := &pending[i]
+		if opts.Group && sf.noTransaction {
+			return nil, fmt.Errorf("%w: %s", ErrGroupNoTransaction, sf.name)
+		}
+		pm := PlannedMigration{
+			Version:       sf.versionStr,
+			Description:   sf.label,
+			Script:        sf.script,
+			Repeatable:    sf.repeatable,
+			Checksum:      sf.checksum,
+			NoTransaction: sf.noTransaction,
+			Timeout:       sf.timeout,
+		}
+		if ss, ok := sf.source.(ScriptSource); ok {
+			mi := sf.info()
+			mi.Placeholders = m.migrationPlaceholders(st, sf)
+			pm.SQL, err = ss.MigrationScript(ctx, mi)
+			if err != nil {
+				return nil, sf.migrationError(err)
+			}
+		}
+		pm.BeforeEachSQL, err = m.callbackScript(ctx, st, CallbackBeforeEachMigrate, sf, CallbackInfo{})
+		if err != nil {
+			return nil, err
+		}
+		pm.AfterEachSQL, err = m.callbackScript(ctx, st, CallbackAfterEachMigrate, sf, CallbackInfo{})
+		if err != nil {
+			return nil, err
+		}
+		plan.Migrations = append(plan.Migrations, pm)
+	}
+
+	plan.AfterMigrateSQL, err = m.callbackScript(ctx, st, CallbackAfterMigrate, nil,
+		CallbackInfo{NumApplied: len(pending)})
+	if err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// callbackScript returns the SQL of the sources' callbacks for the
+// event, in the order runCallbacks would execute them.
+func (m *Migrator) callbackScript(
+	ctx context.Context, st *state, event CallbackEvent, sf *migration, info CallbackInfo,
+) (string, error) {
+	info = m.callbackInfo(st, event, sf, info)
+
+	var sb strings.Builder
+	for _, src := range m.sources {
+		cs, ok := src.(CallbackScriptSource)
+		if !ok {
+			continue
+		}
+		script, err := cs.CallbackScript(ctx, info)
+		if err != nil {
+			return "", fmt.Errorf("fwish: %s callback failed: %w", event, err)
+		}
+		sb.WriteString(script)
+	}
+	return sb.String(), nil
+}
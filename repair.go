@@ -0,0 +1,257 @@
+package fwish
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrRepairPlanChanged is returned by RepairPlanned when the changes
+// Repair would make differ from the planned ones, e.g., because the
+// metadata table has been modified in the meantime.
+var ErrRepairPlanChanged = errors.New("fwish: repair plan changed")
+
+// RepairActionKind classifies the changes made by Repair.
+type RepairActionKind string
+
+const (
+	// RepairActionRemoveFailed removes a row of a failed migration from
+	// the metadata table.
+	RepairActionRemoveFailed RepairActionKind = "remove-failed"
+	// RepairActionAlignChecksum updates the checksum of a row to match
+	// the one from the sources.
+	RepairActionAlignChecksum RepairActionKind = "align-checksum"
+	// RepairActionAlignDescription updates the description of a row to
+	// match the one from the sources.
+	RepairActionAlignDescription RepairActionKind = "align-description"
+)
+
+// RepairAction describes a change to the metadata table made by Repair.
+type RepairAction struct {
+	Kind          RepairActionKind
+	InstalledRank int32
+	Version       string
+	Script        string
+	// From and To hold the old and the new value for alignment actions.
+	From string
+	To   string
+}
+
+// String returns the human-readable description of the action.
+func (a RepairAction) String() string {
+	switch a.Kind {
+	case RepairActionRemoveFailed:
+		return fmt.Sprintf("remove failed migration %s (rank %d): %s",
+			a.Version, a.InstalledRank, a.Script)
+	case RepairActionAlignChecksum:
+		return fmt.Sprintf("align checksum of migration %s (rank %d) from %s to %s",
+			a.Version, a.InstalledRank, a.From, a.To)
+	case RepairActionAlignDescription:
+		return fmt.Sprintf("align description of migration %s (rank %d) from %q to %q",
+			a.Version, a.InstalledRank, a.From, a.To)
+	}
+	return string(a.Kind)
+}
+
+// PlanRepair returns the changes Repair would make without applying
+// them.
+func (m *Migrator) PlanRepair(db DB, schemaName string) ([]RepairAction, error) {
+	return m.PlanRepairContext(context.Background(), contextDB(db), schemaName)
+}
+
+// PlanRepairContext is the context-aware variant of PlanRepair.
+func (m *Migrator) PlanRepairContext(
+	ctx context.Context, db ContextDB, schemaName string,
+) ([]RepairAction, error) {
+	st, err := m.newState(db, schemaName)
+	if err != nil {
+		return nil, err
+	}
+	return m.repairActions(ctx, st)
+}
+
+// Repair fixes the metadata table so that the schema could be migrated
+// again. It removes the rows of failed migrations and realigns the
+// checksums and the descriptions of applied migrations with the ones
+// from the sources. It returns the changes it made, which are also
+// written to the logger.
+//
+// Repair does not revert the effects of failed migrations. Those need
+// to be cleaned up manually before the next Migrate.
+func (m *Migrator) Repair(db DB, schemaName string) ([]RepairAction, error) {
+	return m.RepairContext(context.Background(), contextDB(db), schemaName)
+}
+
+// RepairContext is the context-aware variant of Repair.
+func (m *Migrator) RepairContext(
+	ctx context.Context, db ContextDB, schemaName string,
+) ([]RepairAction, error) {
+	return m.repair(ctx, db, schemaName, nil)
+}
+
+// RepairPlanned is Repair which makes only the changes returned by
+// PlanRepair, e.g., after they have been confirmed by the user. The
+// changes are planned again once the schema is locked; if they differ,
+// nothing is changed and ErrRepairPlanChanged is returned.
+func (m *Migrator) RepairPlanned(db DB, schemaName string, planned []RepairAction) ([]RepairAction, error) {
+	return m.RepairPlannedContext(context.Background(), contextDB(db), schemaName, planned)
+}
+
+// RepairPlannedContext is the context-aware variant of RepairPlanned.
+func (m *Migrator) RepairPlannedContext(
+	ctx context.Context, db ContextDB, schemaName string, planned []RepairAction,
+) ([]RepairAction, error) {
+	if planned == nil {
+		// So that it's told apart from Repair's
+		planned = []RepairAction{}
+	}
+	return m.repair(ctx, db, schemaName, planned)
+}
+
+// repair makes the repair actions. If planned is not nil, the actions
+// must match it.
+func (m *Migrator) repair(
+	ctx context.Context, db ContextDB, schemaName string, planned []RepairAction,
+) ([]RepairAction, error) {
+	st, err := m.newState(db, schemaName)
+	if err != nil {
+		return nil, err
+	}
+
+	releaseConn, err := m.pinConn(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseConn()
+
+	releaseLock, err := m.acquireLock(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseLock()
+
+	actions, err := m.repairActions(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	if planned != nil && !equalRepairActions(actions, planned) {
+		return nil, ErrRepairPlanChanged
+	}
+	if len(actions) == 0 {
+		return nil, nil
+	}
+
+	err = doTx(ctx, st.db, func(tx *sql.Tx) error {
+		for _, a := range actions {
+			var err error
+			switch a.Kind {
+			case RepairActionRemoveFailed:
+				_, err = tx.ExecContext(ctx, fmt.Sprintf(
+					`DELETE FROM %s WHERE installed_rank=$1 AND success IS FALSE`,
+					st.quotedMetatable(),
+				), a.InstalledRank)
+			case RepairActionAlignChecksum:
+				mig := m.migrations[a.Version]
+				_, err = tx.ExecContext(ctx, fmt.Sprintf(
+					`UPDATE %s SET checksum=$1 WHERE installed_rank=$2`,
+					st.quotedMetatable(),
+				), int32(mig.checksum), a.InstalledRank)
+			case RepairActionAlignDescription:
+				_, err = tx.ExecContext(ctx, fmt.Sprintf(
+					`UPDATE %s SET description=$1 WHERE installed_rank=$2`,
+					st.quotedMetatable(),
+				), a.To, a.InstalledRank)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range actions {
+		m.logf("Repaired schema %q: %s", st.schemaName, a)
+	}
+
+	return actions, nil
+}
+
+func equalRepairActions(a, b []RepairAction) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Migrator) repairActions(ctx context.Context, st *state) ([]RepairAction, error) {
+	rows, err := readHistory(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	status := m.resolveStatus(st, rows)
+
+	// Only fix what belongs to this migrator.
+	for _, p := range m.validateHistory(rows, status) {
+		switch p.Kind {
+		case ValidationProblemSchemaIDMismatch, ValidationProblemInsequentialRank:
+			return nil, &p
+		}
+	}
+
+	var actions []RepairAction
+	for _, ms := range status.Migrations {
+		if ms.InstalledRank == 0 {
+			continue
+		}
+		if ms.State == MigrationStateFailed {
+			actions = append(actions, RepairAction{
+				Kind:          RepairActionRemoveFailed,
+				InstalledRank: ms.InstalledRank,
+				Version:       ms.Version,
+				Script:        ms.Script,
+			})
+			continue
+		}
+		// Only the rows of applied versioned migrations are realigned
+		if ms.Repeatable || ms.Type != MigrationTypeSQL ||
+			(ms.State != MigrationStateApplied && ms.State != MigrationStateOutOfOrder &&
+				ms.State != MigrationStateChecksumMismatch) {
+			continue
+		}
+		mig, ok := m.migrations[ms.Version]
+		if !ok {
+			continue
+		}
+		if ms.Checksum != mig.checksum {
+			actions = append(actions, RepairAction{
+				Kind:          RepairActionAlignChecksum,
+				InstalledRank: ms.InstalledRank,
+				Version:       ms.Version,
+				Script:        ms.Script,
+				From:          fmt.Sprint(int32(ms.Checksum)),
+				To:            fmt.Sprint(int32(mig.checksum)),
+			})
+		}
+		if ms.Description != mig.label {
+			actions = append(actions, RepairAction{
+				Kind:          RepairActionAlignDescription,
+				InstalledRank: ms.InstalledRank,
+				Version:       ms.Version,
+				Script:        ms.Script,
+				From:          ms.Description,
+				To:            mig.label,
+			})
+		}
+	}
+
+	return actions, nil
+}
@@ -0,0 +1,115 @@
+package sql
+
+import (
+	"hash/crc32"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Checksum calculates the checksum of a script the same way Flyway's
+// ChecksumCalculator does so that the schemas migrated by Flyway
+// validate under fwish, and vice versa:
+//
+//   - the script is decoded as UTF-8, with each invalid sequence
+//     replaced by U+FFFD like Java's decoder does;
+//   - the byte order mark at the start of the script is dropped;
+//   - the script is read line by line, with any of \n, \r\n and \r
+//     terminating a line, and the CRC-32 is calculated over the UTF-8
+//     of the lines without their line breaks. Other whitespace is kept.
+func Checksum(r io.Reader) (uint32, error) {
+	_, cksum, err := readScript(r)
+	return cksum, err
+}
+
+// readScript reads a script and calculates its checksum. The returned
+// script is normalized the same way the checksum sees it, i.e., without
+// the byte order mark, with \n line breaks and with the invalid UTF-8
+// sequences replaced. Each line in the returned script, including the
+// last, is terminated by a \n.
+func readScript(r io.Reader) (script string, checksum uint32, err error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", 0, err
+	}
+
+	s := strings.TrimPrefix(decodeUTF8(b), "\uFEFF")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+
+	ck := crc32.NewIEEE()
+	var sb strings.Builder
+	for len(s) > 0 {
+		line := s
+		if i := strings.IndexByte(s, '\n'); i >= 0 {
+			line, s = s[:i], s[i+1:]
+		} else {
+			s = ""
+		}
+		// nolint: errcheck
+		io.WriteString(ck, line)
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+
+	return sb.String(), ck.Sum32(), nil
+}
+
+// decodeUTF8 returns b as a string with each maximal subpart of the
+// invalid sequences replaced by U+FFFD, as recommended by Unicode and
+// as done by Java's UTF-8 decoder.
+func decodeUTF8(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+
+	var sb strings.Builder
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r != utf8.RuneError || size > 1 {
+			sb.Write(b[:size])
+			b = b[size:]
+			continue
+		}
+		sb.WriteRune(utf8.RuneError)
+		b = b[invalidSubpartLen(b):]
+	}
+	return sb.String()
+}
+
+// invalidSubpartLen returns the length of the maximal subpart of the
+// invalid sequence at the start of b, i.e., the lead byte followed by
+// the continuation bytes which could still form a valid sequence.
+func invalidSubpartLen(b []byte) int {
+	// The valid range of the second byte, and the number of the
+	// continuation bytes, by the lead byte.
+	var lo, hi byte = 0x80, 0xBF
+	var n int
+	switch c := b[0]; {
+	case c >= 0xC2 && c <= 0xDF:
+		n = 1
+	case c == 0xE0:
+		lo, n = 0xA0, 2
+	case c == 0xED:
+		hi, n = 0x9F, 2
+	case c >= 0xE1 && c <= 0xEF:
+		n = 2
+	case c == 0xF0:
+		lo, n = 0x90, 3
+	case c >= 0xF1 && c <= 0xF3:
+		n = 3
+	case c == 0xF4:
+		hi, n = 0x8F, 3
+	default:
+		return 1
+	}
+
+	i := 1
+	for ; i <= n && i < len(b); i++ {
+		if b[i] < lo || b[i] > hi {
+			break
+		}
+		lo, hi = 0x80, 0xBF
+	}
+	return i
+}
@@ -0,0 +1,59 @@
+package sql_test
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	sqlsource "github.com/rez-go/fwish/sources/sql"
+)
+
+func TestChecksumGolden(t *testing.T) {
+	dir := filepath.Join("testdata", "checksum")
+	fh, err := os.Open(filepath.Join(dir, "checksums.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	var numCases int
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			t.Fatalf("invalid golden line %q", line)
+		}
+		expected, err := strconv.ParseInt(fields[1], 10, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		script, err := os.Open(filepath.Join(dir, fields[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cksum, err := sqlsource.Checksum(script)
+		script.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Flyway stores the checksum as a signed integer
+		if int32(cksum) != int32(expected) {
+			t.Errorf("%s: expected %d, got %d", fields[0], expected, int32(cksum))
+		}
+		numCases++
+	}
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if numCases == 0 {
+		t.Fatal("no golden cases")
+	}
+}
@@ -0,0 +1,172 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/rez-go/fwish"
+)
+
+// preparer is implemented by sql.DB, sql.Conn and sql.Tx. The COPY data
+// are sent through a prepared COPY statement, the way lib/pq supports
+// COPY FROM stdin.
+type preparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// txBeginner is implemented by sql.DB and sql.Conn, i.e., when the
+// migration is executed without a transaction.
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// execCopy executes a COPY ... FROM stdin statement with its data.
+func execCopy(ctx context.Context, db fwish.Executor, stmt Statement) error {
+	if err := checkCopyFormat(stmt.Text); err != nil {
+		return err
+	}
+	// lib/pq supports COPY FROM stdin only in a transaction so the
+	// statement gets its own when the migration has none.
+	if b, ok := db.(txBeginner); ok {
+		tx, err := b.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err = copyRows(ctx, tx, stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
+	return copyRows(ctx, db, stmt)
+}
+
+func copyRows(ctx context.Context, db fwish.Executor, stmt Statement) error {
+	p, ok := db.(preparer)
+	if !ok {
+		return errors.New("COPY FROM stdin requires a DB which supports prepared statements")
+	}
+
+	cs, err := p.PrepareContext(ctx, stmt.Text)
+	if err != nil {
+		return err
+	}
+	defer cs.Close()
+
+	for i, row := range stmt.CopyData {
+		values, err := parseCopyTextRow(row)
+		if err != nil {
+			return errors.New("COPY data row " + strconv.Itoa(i+1) + ": " + err.Error())
+		}
+		if _, err = cs.ExecContext(ctx, values...); err != nil {
+			return err
+		}
+	}
+
+	// Executing without values flushes the data
+	_, err = cs.ExecContext(ctx)
+	return err
+}
+
+// checkCopyFormat ensures that the COPY statement uses the text format
+// with the default delimiter and null string, which are what
+// parseCopyTextRow understands.
+func checkCopyFormat(stmt string) error {
+	words := strings.FieldsFunc(strings.ToUpper(stmt), func(r rune) bool {
+		return isSpace(r) || r == '(' || r == ')' || r == ','
+	})
+	// The options follow the FROM STDIN
+	for len(words) > 0 && !strings.HasPrefix(words[0], "STDIN") {
+		words = words[1:]
+	}
+	for _, w := range words {
+		switch w {
+		case "CSV", "BINARY", "DELIMITER", "NULL", "HEADER", "QUOTE", "ESCAPE":
+			return errors.New("COPY FROM stdin supports only the text format with the default options")
+		}
+	}
+	return nil
+}
+
+// parseCopyTextRow parses a row of COPY text format into the column
+// values. \N is parsed as NULL.
+func parseCopyTextRow(row string) ([]interface{}, error) {
+	cols := strings.Split(row, "\t")
+	values := make([]interface{}, len(cols))
+	for i, col := range cols {
+		if col == `\N` {
+			continue
+		}
+		s, err := unescapeCopyText(col)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = s
+	}
+	return values, nil
+}
+
+func unescapeCopyText(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return "", errors.New("incomplete escape sequence")
+		}
+		switch c := s[i]; c {
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case 'x':
+			// \xh or \xhh
+			j := i + 1
+			for j < len(s) && j < i+3 && isHexDigit(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				sb.WriteByte('x')
+				continue
+			}
+			v, _ := strconv.ParseUint(s[i+1:j], 16, 8)
+			sb.WriteByte(byte(v))
+			i = j - 1
+		default:
+			if c >= '0' && c <= '7' {
+				// \o, \oo or \ooo
+				j := i
+				for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+					j++
+				}
+				v, _ := strconv.ParseUint(s[i:j], 8, 16)
+				sb.WriteByte(byte(v))
+				i = j - 1
+				continue
+			}
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), nil
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
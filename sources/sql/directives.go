@@ -0,0 +1,136 @@
+package sql
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The prefix of the directive comments in the header of a script, e.g.,
+//
+//	-- fwish:no-transaction
+//	-- fwish:timeout=10m
+const directivePrefix = "fwish:"
+
+// The suffix of the script config files. Like Flyway's, the config of
+// V1__Init.sql is V1__Init.sql.conf.
+const scriptConfigSuffix = ".conf"
+
+// scriptDirectives holds the execution options of a script.
+type scriptDirectives struct {
+	noTransaction bool
+	timeout       time.Duration
+}
+
+// readDirectives loads the directives of the script from its header
+// comments and from its config file, if any. The config file takes
+// precedence.
+func (src *sqlFSSource) readDirectives(filename string) (scriptDirectives, error) {
+	var d scriptDirectives
+
+	fh, err := src.fs.Open(filename)
+	if err != nil {
+		return d, fmt.Errorf("fwish.sql: unable to load migration file: %w", err)
+	}
+	defer fh.Close()
+
+	// Read the way the script is executed, e.g., without the byte
+	// order mark.
+	script, _, err := readScript(fh)
+	if err != nil {
+		return d, fmt.Errorf("fwish.sql: unable to load migration file: %w", err)
+	}
+
+	// The header ends at the first line which is not a comment.
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "--"))
+		if !strings.HasPrefix(line, directivePrefix) {
+			continue
+		}
+		key, value, _ := strings.Cut(strings.TrimPrefix(line, directivePrefix), "=")
+		if err = d.set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return d, fmt.Errorf("fwish.sql: %s: %w", filename, err)
+		}
+	}
+
+	err = src.readScriptConfig(filename+scriptConfigSuffix, &d)
+	return d, err
+}
+
+// readScriptConfig loads the script config file into d. The file has
+// a key=value pair per line. Lines starting with # are comments. It
+// accepts Flyway's executeInTransaction key and the keys of the
+// directives.
+func (src *sqlFSSource) readScriptConfig(filename string, d *scriptDirectives) error {
+	fh, err := src.fs.Open(filename)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("fwish.sql: unable to load script config file: %w", err)
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("fwish.sql: %s: invalid line %q", filename, line)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if key == "executeInTransaction" {
+			inTx, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("fwish.sql: %s: invalid value for %s: %w", filename, key, err)
+			}
+			d.noTransaction = !inTx
+			continue
+		}
+		if err = d.set(key, value); err != nil {
+			return fmt.Errorf("fwish.sql: %s: %w", filename, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (d *scriptDirectives) set(key, value string) error {
+	switch key {
+	case "no-transaction":
+		if value == "" {
+			d.noTransaction = true
+			return nil
+		}
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+		d.noTransaction = v
+	case "timeout":
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+		if v < 0 {
+			return fmt.Errorf("invalid value for %s: negative duration", key)
+		}
+		d.timeout = v
+	default:
+		return fmt.Errorf("unknown directive %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package sql
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+
+	"github.com/rez-go/fwish"
+)
+
+// statementError creates a *fwish.MigrationError which points to the
+// location of the error in the script. The location is the error
+// position reported by PostgreSQL or the location of the placeholder
+// without value, or the start of the statement if there's none.
+//
+// The index is the 1-based index of the statement in the script. The
+// executed statement is the statement after the placeholders
+// substitution recorded in subs.
+func statementError(
+	sm fwish.MigrationInfo, script string, index int, stmt Statement,
+	executed string, subs []substitution, err error,
+) error {
+	offset := stmt.Offset
+	var pqErr *pq.Error
+	var phErr *placeholderError
+	switch {
+	case errors.As(err, &pqErr) && pqErr.Position != "":
+		// The position is 1-based and in characters
+		if pos, perr := strconv.Atoi(pqErr.Position); perr == nil && pos > 0 {
+			offset += originalOffset(subs, runeOffset(executed, pos-1))
+		}
+	case errors.As(err, &phErr) && !phErr.inCopyData:
+		offset += phErr.offset
+	}
+
+	line, column := lineColumn(script, offset)
+	return &fwish.MigrationError{
+		Script:         sm.Script,
+		Statement:      executed,
+		StatementIndex: index,
+		Line:           line,
+		Column:         column,
+		Err:            err,
+	}
+}
+
+// runeOffset returns the byte offset of the n-th character of s.
+func runeOffset(s string, n int) int {
+	offset := 0
+	for i := 0; i < n && offset < len(s); i++ {
+		_, size := utf8.DecodeRuneInString(s[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// lineColumn returns the line and the column, both 1-based and in
+// characters, of the byte offset in s.
+func lineColumn(s string, offset int) (line, column int) {
+	before := s[:offset]
+	line = strings.Count(before, "\n") + 1
+	lineStart := strings.LastIndexByte(before, '\n') + 1
+	column = utf8.RuneCountInString(before[lineStart:]) + 1
+	return line, column
+}
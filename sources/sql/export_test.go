@@ -0,0 +1,7 @@
+package sql
+
+// Exported for the tests in sql_test.
+var (
+	ReplacePlaceholders = replacePlaceholders
+	OriginalOffset      = originalOffset
+)
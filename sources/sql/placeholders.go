@@ -0,0 +1,105 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// placeholderPattern matches the placeholders like Flyway's, e.g.,
+// ${tableName} and ${fwish:defaultSchema}.
+var placeholderPattern = regexp.MustCompile(`\$\{([A-Za-z0-9_.:-]+)\}`)
+
+// placeholderError is returned when a placeholder has no value.
+type placeholderError struct {
+	name string
+	// offset is the byte offset of the placeholder in the text.
+	offset int
+	// inCopyData is true if the placeholder is in a COPY data row, in
+	// which case the offset is relative to the row.
+	inCopyData bool
+}
+
+func (e *placeholderError) Error() string {
+	return fmt.Sprintf("fwish.sql: no value provided for placeholder ${%s}", e.name)
+}
+
+// substitution records a replaced placeholder so that the offsets in
+// the substituted text could be mapped back to the original text.
+type substitution struct {
+	// offset is the byte offset of the placeholder in the original text.
+	offset int
+	oldLen int
+	newLen int
+}
+
+// replacePlaceholders substitutes the placeholders in s with their
+// values. All the placeholders must have a value.
+func replacePlaceholders(s string, values map[string]string) (string, []substitution, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil, nil
+	}
+
+	var sb strings.Builder
+	var subs []substitution
+	last := 0
+	for _, loc := range placeholderPattern.FindAllStringSubmatchIndex(s, -1) {
+		name := s[loc[2]:loc[3]]
+		v, ok := values[name]
+		if !ok {
+			return "", nil, &placeholderError{name: name, offset: loc[0]}
+		}
+		sb.WriteString(s[last:loc[0]])
+		sb.WriteString(v)
+		subs = append(subs, substitution{offset: loc[0], oldLen: loc[1] - loc[0], newLen: len(v)})
+		last = loc[1]
+	}
+	sb.WriteString(s[last:])
+	return sb.String(), subs, nil
+}
+
+// replaceStatementPlaceholders substitutes the placeholders in the
+// statement, including its COPY data. The substitutions are of the
+// statement's text.
+func replaceStatementPlaceholders(
+	stmt Statement, values map[string]string,
+) (Statement, []substitution, error) {
+	text, subs, err := replacePlaceholders(stmt.Text, values)
+	if err != nil {
+		return stmt, nil, err
+	}
+	stmt.Text = text
+	if stmt.CopyData != nil {
+		rows := make([]string, len(stmt.CopyData))
+		for i, row := range stmt.CopyData {
+			if rows[i], _, err = replacePlaceholders(row, values); err != nil {
+				var phErr *placeholderError
+				if errors.As(err, &phErr) {
+					phErr.inCopyData = true
+				}
+				return stmt, nil, err
+			}
+		}
+		stmt.CopyData = rows
+	}
+	return stmt, subs, nil
+}
+
+// originalOffset maps the byte offset in the substituted text to the
+// offset in the original text. An offset inside a substituted value is
+// mapped to the placeholder.
+func originalOffset(subs []substitution, offset int) int {
+	delta := 0
+	for _, sub := range subs {
+		start := sub.offset + delta
+		if offset < start {
+			break
+		}
+		if offset < start+sub.newLen {
+			return sub.offset
+		}
+		delta += sub.newLen - sub.oldLen
+	}
+	return offset - delta
+}
@@ -0,0 +1,76 @@
+package sql_test
+
+import (
+	"strings"
+	"testing"
+
+	sqlsource "github.com/rez-go/fwish/sources/sql"
+)
+
+func TestReplacePlaceholders(t *testing.T) {
+	values := map[string]string{"a": "x", "b": "longer", "fwish:defaultSchema": "app"}
+
+	text, subs, err := sqlsource.ReplacePlaceholders("SELECT 1", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "SELECT 1" || subs != nil {
+		t.Fatalf("unexpected substitution %q %v", text, subs)
+	}
+
+	text, _, err = sqlsource.ReplacePlaceholders("SELECT * FROM ${fwish:defaultSchema}.t, ${a}", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "SELECT * FROM app.t, x" {
+		t.Fatalf("unexpected substitution %q", text)
+	}
+
+	// Not placeholders
+	text, _, err = sqlsource.ReplacePlaceholders("SELECT '$a', '${}', '${a b}'", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "SELECT '$a', '${}', '${a b}'" {
+		t.Fatalf("unexpected substitution %q", text)
+	}
+
+	_, _, err = sqlsource.ReplacePlaceholders("SELECT ${a}, ${nope}", values)
+	if err == nil || !strings.Contains(err.Error(), "${nope}") {
+		t.Fatalf("missing placeholder error expected, got %v", err)
+	}
+}
+
+func TestOriginalOffset(t *testing.T) {
+	// SELECT ${a}, '${b}'
+	// SELECT x, 'longer'
+	text, subs, err := sqlsource.ReplacePlaceholders("SELECT ${a}, '${b}'",
+		map[string]string{"a": "x", "b": "longer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "SELECT x, 'longer'" {
+		t.Fatalf("unexpected substitution %q", text)
+	}
+
+	cases := []struct {
+		offset   int
+		expected int
+	}{
+		{0, 0},
+		{6, 6},
+		// Inside the values, mapped to the placeholders
+		{7, 7},
+		{11, 14},
+		{16, 14},
+		// After the values
+		{8, 11},
+		{10, 13},
+		{17, 18},
+	}
+	for _, c := range cases {
+		if o := sqlsource.OriginalOffset(subs, c.offset); o != c.expected {
+			t.Errorf("offset %d: %d expected, got %d", c.offset, c.expected, o)
+		}
+	}
+}
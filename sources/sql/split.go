@@ -0,0 +1,377 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Statement is a statement of a script as split by SplitStatements.
+type Statement struct {
+	// Text is the statement without the leading comments and the
+	// terminating semicolon.
+	Text string
+	// Offset is the byte offset of Text in the script.
+	Offset int
+	// Line is the line number, 1-based, where Text starts in the script.
+	Line int
+	// CopyData holds the data rows of a COPY ... FROM stdin statement,
+	// without the terminating \. line. CopyData is nil for other kind
+	// of statements.
+	CopyData []string
+}
+
+// IsCopyFromStdin returns true if the statement is a COPY which
+// reads its data from the script.
+func (s Statement) IsCopyFromStdin() bool {
+	return s.CopyData != nil
+}
+
+// SplitStatements splits a PostgreSQL script into its statements. It
+// understands comments, including the nested block comments, string
+// literals, quoted identifiers, dollar-quoted strings, the bodies of
+// SQL-standard functions (BEGIN ATOMIC ... END), the multiple-command
+// actions of rules (DO ALSO (...; ...)) and the inline data of COPY ...
+// FROM stdin statements.
+func SplitStatements(script string) ([]Statement, error) {
+	sp := splitter{script: script, line: 1}
+	return sp.split()
+}
+
+type splitter struct {
+	script string
+	pos    int
+	line   int
+}
+
+func (sp *splitter) split() ([]Statement, error) {
+	var stmts []Statement
+	for {
+		sp.skipSpaceAndComments()
+		if sp.pos >= len(sp.script) {
+			return stmts, nil
+		}
+		if sp.script[sp.pos] == ';' {
+			sp.pos++
+			continue
+		}
+
+		stmt := Statement{Offset: sp.pos, Line: sp.line}
+		end, err := sp.scanStatement()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Text = strings.TrimRightFunc(sp.script[stmt.Offset:end], isSpace)
+
+		if isCopyFromStdin(stmt.Text) {
+			stmt.CopyData, err = sp.scanCopyData(stmt.Line)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		stmts = append(stmts, stmt)
+	}
+}
+
+// scanStatement advances to the end of the current statement. It
+// returns the end offset of the statement, excluding the semicolon.
+func (sp *splitter) scanStatement() (end int, err error) {
+	var words []string // the leading words, to detect the routines
+	// The word right before, to detect BEGIN ATOMIC
+	var prevWord string
+	atomicDepth := 0
+	parenDepth := 0
+	for sp.pos < len(sp.script) {
+		c := sp.script[sp.pos]
+		switch {
+		case c == ';':
+			if atomicDepth > 0 || (parenDepth > 0 && isRuleDefinition(words)) {
+				sp.pos++
+				continue
+			}
+			end = sp.pos
+			sp.pos++
+			return end, nil
+		case c == '\n':
+			sp.line++
+			sp.pos++
+		case c == '-' && sp.peek(1) == '-':
+			sp.skipLineComment()
+		case c == '/' && sp.peek(1) == '*':
+			if err = sp.skipBlockComment(); err != nil {
+				return 0, err
+			}
+		case c == '\'':
+			escapes := sp.pos > 0 && (sp.script[sp.pos-1] == 'e' || sp.script[sp.pos-1] == 'E') &&
+				(sp.pos < 2 || !isIdentChar(sp.script[sp.pos-2]))
+			if err = sp.skipQuoted('\'', escapes); err != nil {
+				return 0, err
+			}
+		case c == '"':
+			if err = sp.skipQuoted('"', false); err != nil {
+				return 0, err
+			}
+		case c == '$' && (sp.pos == 0 || !isIdentChar(sp.script[sp.pos-1])):
+			tag, ok := sp.dollarTag()
+			if !ok {
+				sp.pos++
+				continue
+			}
+			if err = sp.skipDollarQuoted(tag); err != nil {
+				return 0, err
+			}
+		case isIdentStart(c):
+			start := sp.pos
+			for sp.pos < len(sp.script) && isIdentChar(sp.script[sp.pos]) {
+				sp.pos++
+			}
+			word := strings.ToUpper(sp.script[start:sp.pos])
+			if len(words) < 4 {
+				words = append(words, word)
+			}
+			prev := prevWord
+			prevWord = word
+			if !isRoutineDefinition(words) {
+				continue
+			}
+			// Only BEGIN ATOMIC starts a body; BEGIN alone could be,
+			// e.g., the name of a parameter.
+			switch {
+			case word == "ATOMIC" && prev == "BEGIN" && atomicDepth == 0:
+				atomicDepth++
+			case word == "CASE" && atomicDepth > 0:
+				// CASE could be used in the body too and it's
+				// terminated by END as well.
+				atomicDepth++
+			case word == "END" && atomicDepth > 0:
+				atomicDepth--
+			}
+		default:
+			switch {
+			case c == '(':
+				parenDepth++
+			case c == ')' && parenDepth > 0:
+				parenDepth--
+			}
+			if !isSpace(rune(c)) {
+				prevWord = ""
+			}
+			sp.pos++
+		}
+	}
+	return sp.pos, nil
+}
+
+// scanCopyData reads the data rows which follow a COPY ... FROM stdin
+// statement up to the \. line.
+func (sp *splitter) scanCopyData(stmtLine int) ([]string, error) {
+	// The data starts at the line after the statement
+	if i := strings.IndexByte(sp.script[sp.pos:], '\n'); i >= 0 {
+		sp.pos += i + 1
+		sp.line++
+	} else {
+		sp.pos = len(sp.script)
+	}
+
+	rows := []string{}
+	for sp.pos < len(sp.script) {
+		row := sp.script[sp.pos:]
+		if i := strings.IndexByte(row, '\n'); i >= 0 {
+			row = row[:i]
+			sp.pos += i + 1
+			sp.line++
+		} else {
+			sp.pos = len(sp.script)
+		}
+		row = strings.TrimSuffix(row, "\r")
+		if row == `\.` {
+			return rows, nil
+		}
+		rows = append(rows, row)
+	}
+	return nil, fmt.Errorf("line %d: COPY data is not terminated by \\.", stmtLine)
+}
+
+func (sp *splitter) peek(n int) byte {
+	if sp.pos+n < len(sp.script) {
+		return sp.script[sp.pos+n]
+	}
+	return 0
+}
+
+func (sp *splitter) skipSpaceAndComments() {
+	for sp.pos < len(sp.script) {
+		c := sp.script[sp.pos]
+		switch {
+		case c == '\n':
+			sp.line++
+			sp.pos++
+		case isSpace(rune(c)):
+			sp.pos++
+		case c == '-' && sp.peek(1) == '-':
+			sp.skipLineComment()
+		case c == '/' && sp.peek(1) == '*':
+			if sp.skipBlockComment() != nil {
+				// Let the statement scanner report it
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (sp *splitter) skipLineComment() {
+	if i := strings.IndexByte(sp.script[sp.pos:], '\n'); i >= 0 {
+		sp.pos += i
+	} else {
+		sp.pos = len(sp.script)
+	}
+}
+
+func (sp *splitter) skipBlockComment() error {
+	start, startLine := sp.pos, sp.line
+	depth := 0
+	for sp.pos < len(sp.script) {
+		switch {
+		case sp.script[sp.pos] == '/' && sp.peek(1) == '*':
+			depth++
+			sp.pos += 2
+		case sp.script[sp.pos] == '*' && sp.peek(1) == '/':
+			depth--
+			sp.pos += 2
+			if depth == 0 {
+				return nil
+			}
+		default:
+			if sp.script[sp.pos] == '\n' {
+				sp.line++
+			}
+			sp.pos++
+		}
+	}
+	sp.pos, sp.line = start, startLine
+	return fmt.Errorf("line %d: unterminated block comment", startLine)
+}
+
+// skipQuoted skips a string literal or a quoted identifier. A doubled
+// quote is an escaped quote. If backslashEscapes is true, as in E'...'
+// strings, a backslash escapes the next character.
+func (sp *splitter) skipQuoted(quote byte, backslashEscapes bool) error {
+	startLine := sp.line
+	sp.pos++
+	for sp.pos < len(sp.script) {
+		c := sp.script[sp.pos]
+		switch {
+		case c == '\\' && backslashEscapes:
+			if sp.peek(1) == '\n' {
+				sp.line++
+			}
+			sp.pos += 2
+			continue
+		case c == quote:
+			if sp.peek(1) == quote {
+				sp.pos += 2
+				continue
+			}
+			sp.pos++
+			return nil
+		case c == '\n':
+			sp.line++
+		}
+		sp.pos++
+	}
+	if quote == '"' {
+		return fmt.Errorf("line %d: unterminated quoted identifier", startLine)
+	}
+	return fmt.Errorf("line %d: unterminated quoted string", startLine)
+}
+
+// dollarTag returns the tag, including the dollar signs, if the script
+// at the current position is the opening of a dollar-quoted string.
+func (sp *splitter) dollarTag() (string, bool) {
+	i := sp.pos + 1
+	if i < len(sp.script) && sp.script[i] != '$' {
+		if !isIdentStart(sp.script[i]) {
+			// e.g., the $1 parameters
+			return "", false
+		}
+		for i < len(sp.script) && isIdentChar(sp.script[i]) && sp.script[i] != '$' {
+			i++
+		}
+	}
+	if i >= len(sp.script) || sp.script[i] != '$' {
+		return "", false
+	}
+	return sp.script[sp.pos : i+1], true
+}
+
+func (sp *splitter) skipDollarQuoted(tag string) error {
+	startLine := sp.line
+	body := sp.script[sp.pos+len(tag):]
+	i := strings.Index(body, tag)
+	if i < 0 {
+		return fmt.Errorf("line %d: unterminated dollar-quoted string", startLine)
+	}
+	sp.line += strings.Count(body[:i], "\n")
+	sp.pos += len(tag) + i + len(tag)
+	return nil
+}
+
+// isRoutineDefinition returns true if the leading words are of a
+// CREATE FUNCTION or a CREATE PROCEDURE statement.
+func isRoutineDefinition(words []string) bool {
+	kind := createdKind(words)
+	return kind == "FUNCTION" || kind == "PROCEDURE"
+}
+
+// isRuleDefinition returns true if the leading words are of a CREATE
+// RULE statement, whose action could be multiple commands in
+// parentheses.
+func isRuleDefinition(words []string) bool {
+	return createdKind(words) == "RULE"
+}
+
+// createdKind returns the kind of object created by a CREATE [OR
+// REPLACE] statement, or empty if the leading words are of another
+// statement.
+func createdKind(words []string) string {
+	if len(words) < 2 || words[0] != "CREATE" {
+		return ""
+	}
+	i := 1
+	if words[1] == "OR" {
+		i = 3 // OR REPLACE
+	}
+	if i < len(words) {
+		return words[i]
+	}
+	return ""
+}
+
+// isCopyFromStdin returns true if the statement is a COPY which reads
+// its data from stdin, i.e., from the script.
+func isCopyFromStdin(stmt string) bool {
+	words := strings.Fields(strings.ToUpper(stmt))
+	if len(words) == 0 || words[0] != "COPY" {
+		return false
+	}
+	for i := 1; i+1 < len(words); i++ {
+		if words[i] == "FROM" && strings.HasPrefix(words[i+1], "STDIN") {
+			return true
+		}
+	}
+	return false
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9') || c == '$'
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' || r == '\v'
+}
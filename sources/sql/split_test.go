@@ -0,0 +1,154 @@
+package sql_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	sqlsource "github.com/rez-go/fwish/sources/sql"
+)
+
+func TestSplitStatements(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		texts []string
+		lines []int
+	}{
+		{"empty", "", nil, nil},
+		{"comments only", "-- nothing\n/* here */\n", nil, nil},
+		{"simple",
+			"CREATE TABLE a (id int);\nCREATE TABLE b (id int);\n",
+			[]string{"CREATE TABLE a (id int)", "CREATE TABLE b (id int)"},
+			[]int{1, 2}},
+		{"no trailing semicolon",
+			"SELECT 1;\n\nSELECT 2\n",
+			[]string{"SELECT 1", "SELECT 2"},
+			[]int{1, 3}},
+		{"leading comments",
+			"-- first\n/* multi\nline */ SELECT 1;",
+			[]string{"SELECT 1"},
+			[]int{3}},
+		{"nested block comment",
+			"/* a /* b; */ c; */ SELECT 1; SELECT 2;",
+			[]string{"SELECT 1", "SELECT 2"},
+			[]int{1, 1}},
+		{"semicolon in line comment",
+			"SELECT 1 -- not; the end\n+ 1;",
+			[]string{"SELECT 1 -- not; the end\n+ 1"},
+			[]int{1}},
+		{"string literals",
+			"SELECT 'a;b', 'it''s;';\nSELECT E'\\';', \"x;y\";",
+			[]string{"SELECT 'a;b', 'it''s;'", "SELECT E'\\';', \"x;y\""},
+			[]int{1, 2}},
+		{"dollar quoting",
+			"CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n  RETURN 1;\nEND;\n$$ LANGUAGE plpgsql;\n" +
+				"SELECT $tag$ $$; $tag$, $1;",
+			[]string{
+				"CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n  RETURN 1;\nEND;\n$$ LANGUAGE plpgsql",
+				"SELECT $tag$ $$; $tag$, $1",
+			},
+			[]int{1, 6}},
+		{"begin atomic",
+			"CREATE OR REPLACE FUNCTION f(a int) RETURNS int LANGUAGE sql\nBEGIN ATOMIC\n" +
+				"  SELECT CASE WHEN a > 0 THEN 1 ELSE 0 END;\n  SELECT 2;\nEND;\nSELECT 3;",
+			[]string{
+				"CREATE OR REPLACE FUNCTION f(a int) RETURNS int LANGUAGE sql\nBEGIN ATOMIC\n" +
+					"  SELECT CASE WHEN a > 0 THEN 1 ELSE 0 END;\n  SELECT 2;\nEND",
+				"SELECT 3",
+			},
+			[]int{1, 6}},
+		{"parameter named begin",
+			"CREATE FUNCTION f(begin int) RETURNS int AS $$ SELECT 1 $$ LANGUAGE sql;\nSELECT 2;",
+			[]string{
+				"CREATE FUNCTION f(begin int) RETURNS int AS $$ SELECT 1 $$ LANGUAGE sql",
+				"SELECT 2",
+			},
+			[]int{1, 2}},
+		{"rule with multiple commands",
+			"CREATE OR REPLACE RULE r AS ON INSERT TO t DO ALSO (\n" +
+				"  INSERT INTO log VALUES (new.id);\n  UPDATE c SET n = n + 1;\n);\nSELECT 1;",
+			[]string{
+				"CREATE OR REPLACE RULE r AS ON INSERT TO t DO ALSO (\n" +
+					"  INSERT INTO log VALUES (new.id);\n  UPDATE c SET n = n + 1;\n)",
+				"SELECT 1",
+			},
+			[]int{1, 5}},
+		{"transaction block",
+			"BEGIN;\nSELECT 1;\nEND;",
+			[]string{"BEGIN", "SELECT 1", "END"},
+			[]int{1, 2, 3}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stmts, err := sqlsource.SplitStatements(c.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var texts []string
+			var lines []int
+			for _, s := range stmts {
+				texts = append(texts, s.Text)
+				lines = append(lines, s.Line)
+				if c.input[s.Offset:s.Offset+len(s.Text)] != s.Text {
+					t.Errorf("bad offset %d for %q", s.Offset, s.Text)
+				}
+			}
+			if !reflect.DeepEqual(texts, c.texts) {
+				t.Errorf("expected %q, got %q", c.texts, texts)
+			}
+			if !reflect.DeepEqual(lines, c.lines) {
+				t.Errorf("expected lines %v, got %v", c.lines, lines)
+			}
+		})
+	}
+}
+
+func TestSplitStatementsCopy(t *testing.T) {
+	input := "CREATE TABLE person (id int, name text);\n" +
+		"COPY person (id, name) FROM stdin;\n" +
+		"1\tAlice; the first\n" +
+		"2\t\\N\n" +
+		"\\.\n" +
+		"SELECT 1;\n"
+
+	stmts, err := sqlsource.SplitStatements(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 3 {
+		t.Fatalf("3 statements expected, got %d", len(stmts))
+	}
+	if !stmts[1].IsCopyFromStdin() {
+		t.Fatal("COPY FROM stdin expected")
+	}
+	if !reflect.DeepEqual(stmts[1].CopyData, []string{"1\tAlice; the first", "2\t\\N"}) {
+		t.Fatalf("unexpected COPY data %q", stmts[1].CopyData)
+	}
+	if stmts[0].IsCopyFromStdin() || stmts[2].IsCopyFromStdin() {
+		t.Fatal("only the COPY statement should have data")
+	}
+	if stmts[2].Text != "SELECT 1" || stmts[2].Line != 6 {
+		t.Fatalf("unexpected statement %+v", stmts[2])
+	}
+}
+
+func TestSplitStatementsErrors(t *testing.T) {
+	cases := []struct {
+		input  string
+		errMsg string
+	}{
+		{"SELECT 'abc;", "line 1: unterminated quoted string"},
+		{"SELECT 1;\nSELECT \"abc;", "line 2: unterminated quoted identifier"},
+		{"SELECT $$ abc;", "line 1: unterminated dollar-quoted string"},
+		{"SELECT 1; /* abc", "line 1: unterminated block comment"},
+		{"COPY t FROM stdin;\n1\n", `line 1: COPY data is not terminated by \.`},
+	}
+	for _, c := range cases {
+		_, err := sqlsource.SplitStatements(c.input)
+		if err == nil || !strings.Contains(err.Error(), c.errMsg) {
+			t.Errorf("%q: expected error %q, got %v", c.input, c.errMsg, err)
+		}
+	}
+}
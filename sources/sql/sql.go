@@ -16,4 +16,10 @@ type sqlSourceMeta struct {
 	ID         string
 	Name       string
 	FileSuffix string
+	// Placeholders holds the default values for the placeholders in
+	// the scripts. The values provided by the migrator take precedence.
+	Placeholders map[string]string
+	// PlaceholderReplacement could be set to false to disable the
+	// placeholders substitution. It's enabled by default.
+	PlaceholderReplacement *bool `yaml:"placeholderReplacement"`
 }
@@ -1,9 +1,9 @@
 package sql
 
 import (
-	"bufio"
+	"context"
+	"database/sql"
 	"fmt"
-	"hash/crc32"
 	"io/fs"
 	"strings"
 
@@ -19,6 +19,11 @@ type sqlFSSource struct {
 	fileSuffix string
 	scanned    bool
 	migrations []fwish.MigrationInfo
+	// The callback scripts ordered by their names
+	callbacks map[fwish.CallbackEvent][]fwish.MigrationInfo
+
+	placeholderReplacement bool
+	placeholders           map[string]string
 }
 
 func LoadFS(fs_ fs.FS) (fwish.MigrationSource, error) {
@@ -43,6 +48,9 @@ func LoadFS(fs_ fs.FS) (fwish.MigrationSource, error) {
 		schemaID:   idx.ID,
 		schemaName: idx.Name,
 		fs:         fs_,
+
+		placeholderReplacement: idx.PlaceholderReplacement == nil || *idx.PlaceholderReplacement,
+		placeholders:           idx.Placeholders,
 	}, nil
 }
 
@@ -65,32 +73,192 @@ func (src *sqlFSSource) Migrations() ([]fwish.MigrationInfo, error) {
 }
 
 func (src *sqlFSSource) ExecuteMigration(db fwish.DB, sm fwish.MigrationInfo) error {
-	//TODO: ensure that the it's our migration
-	fh, err := src.fs.Open(sm.Script)
+	return src.ExecuteMigrationContext(context.Background(), legacyExecutor{db}, sm)
+}
+
+// ExecuteMigrationContext executes the statements of the script one by
+// one so that a failure could be attributed to the statement.
+func (src *sqlFSSource) ExecuteMigrationContext(
+	ctx context.Context, db fwish.Executor, sm fwish.MigrationInfo,
+) error {
+	script, err := src.loadScript(sm)
 	if err != nil {
-		return fmt.Errorf("fwish.sql: unable to load migration file: %w", err)
+		return err
+	}
+	stmts, err := SplitStatements(script)
+	if err != nil {
+		return &fwish.MigrationError{Script: sm.Script, Err: fmt.Errorf("fwish.sql: %w", err)}
 	}
-	defer fh.Close()
 
-	var script string
-	scanner := bufio.NewScanner(fh)
-	scanner.Split(bufio.ScanLines)
+	placeholders := src.scriptPlaceholders(sm)
 
-	ck := crc32.NewIEEE()
-	for scanner.Scan() {
-		_, err = ck.Write(scanner.Bytes())
+	for i, stmt := range stmts {
+		// The statements are split before the substitution so that
+		// the errors could be located in the script.
+		executed := stmt
+		var subs []substitution
+		if src.placeholderReplacement {
+			executed, subs, err = replaceStatementPlaceholders(stmt, placeholders)
+			if err != nil {
+				return statementError(sm, script, i+1, stmt, stmt.Text, nil, err)
+			}
+		}
+		if executed.IsCopyFromStdin() {
+			err = execCopy(ctx, db, executed)
+		} else {
+			_, err = db.ExecContext(ctx, executed.Text)
+		}
 		if err != nil {
+			return statementError(sm, script, i+1, stmt, executed.Text, subs, err)
+		}
+	}
+	return nil
+}
+
+// MigrationScript returns the statements of the migration's script as
+// they would be executed by ExecuteMigrationContext, i.e., with the
+// placeholders replaced, each terminated by a semicolon so that the
+// script could be executed with psql.
+func (src *sqlFSSource) MigrationScript(
+	ctx context.Context, sm fwish.MigrationInfo,
+) (string, error) {
+	script, err := src.loadScript(sm)
+	if err != nil {
+		return "", err
+	}
+	stmts, err := SplitStatements(script)
+	if err != nil {
+		return "", &fwish.MigrationError{Script: sm.Script, Err: fmt.Errorf("fwish.sql: %w", err)}
+	}
+
+	placeholders := src.scriptPlaceholders(sm)
+
+	var sb strings.Builder
+	for i, stmt := range stmts {
+		if src.placeholderReplacement {
+			executed, _, err := replaceStatementPlaceholders(stmt, placeholders)
+			if err != nil {
+				return "", statementError(sm, script, i+1, stmt, stmt.Text, nil, err)
+			}
+			stmt = executed
+		}
+		sb.WriteString(stmt.Text)
+		sb.WriteString(";\n")
+		if stmt.IsCopyFromStdin() {
+			for _, row := range stmt.CopyData {
+				sb.WriteString(row)
+				sb.WriteByte('\n')
+			}
+			sb.WriteString("\\.\n")
+		}
+	}
+	return sb.String(), nil
+}
+
+// scriptPlaceholders returns the values for the placeholders in the
+// migration's script, or nil if the placeholder replacement has been
+// disabled. The migration's values override the source's.
+func (src *sqlFSSource) scriptPlaceholders(sm fwish.MigrationInfo) map[string]string {
+	if !src.placeholderReplacement {
+		return nil
+	}
+	placeholders := make(map[string]string, len(src.placeholders)+len(sm.Placeholders))
+	for k, v := range src.placeholders {
+		placeholders[k] = v
+	}
+	for k, v := range sm.Placeholders {
+		placeholders[k] = v
+	}
+	return placeholders
+}
+
+// ExecuteCallback executes the callback scripts for the event, e.g.,
+// beforeMigrate.sql and beforeMigrate__Grant.sql, in the order of their
+// names.
+func (src *sqlFSSource) ExecuteCallback(
+	ctx context.Context, db fwish.Executor, info fwish.CallbackInfo,
+) error {
+	for _, cb := range src.callbacks[info.Event] {
+		cb.Placeholders = info.Placeholders
+		if err := src.ExecuteMigrationContext(ctx, db, cb); err != nil {
 			return err
 		}
-		script += scanner.Text() + "\n"
 	}
+	return nil
+}
 
-	if sm.Checksum != ck.Sum32() {
-		return fmt.Errorf("fwish.sql: bad migration file checksum %q", sm.Name)
+// CallbackScript returns the callback scripts for the event as they
+// would be executed by ExecuteCallback. See MigrationScript.
+func (src *sqlFSSource) CallbackScript(
+	ctx context.Context, info fwish.CallbackInfo,
+) (string, error) {
+	var sb strings.Builder
+	for _, cb := range src.callbacks[info.Event] {
+		cb.Placeholders = info.Placeholders
+		script, err := src.MigrationScript(ctx, cb)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(script)
 	}
+	return sb.String(), nil
+}
 
-	_, err = db.Exec(script)
-	return err
+// callbackEvent returns the event if the name is of a callback script.
+// Like Flyway's, the name is the event optionally followed by a
+// description, e.g., afterMigrate__Refresh_views.
+func callbackEvent(name string) (fwish.CallbackEvent, bool) {
+	for _, event := range fwish.CallbackEvents {
+		if name == string(event) || strings.HasPrefix(name, string(event)+"__") {
+			return event, true
+		}
+	}
+	return "", false
+}
+
+// legacyExecutor adapts a DB which has no context-aware methods.
+type legacyExecutor struct {
+	db fwish.DB
+}
+
+// BeginTx is for the COPY FROM stdin statements, which lib/pq supports
+// only in a transaction.
+func (e legacyExecutor) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return e.db.Begin()
+}
+
+func (e legacyExecutor) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return e.db.Exec(query, args...)
+}
+
+func (e legacyExecutor) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return e.db.Query(query, args...)
+}
+
+func (e legacyExecutor) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return e.db.QueryRow(query, args...)
+}
+
+// loadScript reads the script of the migration and verifies it against
+// the checksum.
+func (src *sqlFSSource) loadScript(sm fwish.MigrationInfo) (string, error) {
+	//TODO: ensure that the it's our migration
+	fh, err := src.fs.Open(sm.Script)
+	if err != nil {
+		return "", fmt.Errorf("fwish.sql: unable to load migration file: %w", err)
+	}
+	defer fh.Close()
+
+	script, cksum, err := readScript(fh)
+	if err != nil {
+		return "", fmt.Errorf("fwish.sql: unable to load migration file: %w", err)
+	}
+
+	if sm.Checksum != cksum {
+		return "", fmt.Errorf("fwish.sql: bad migration file checksum %q", sm.Name)
+	}
+
+	return script, nil
 }
 
 // returns the number of files?
@@ -102,6 +270,7 @@ func (src *sqlFSSource) scanSourceDir() (numFiles int, err error) {
 	ignorePrefix := "_"
 
 	src.migrations = nil
+	src.callbacks = nil
 
 	fl, err := fs.ReadDir(src.fs, ".")
 	if err != nil {
@@ -128,10 +297,30 @@ func (src *sqlFSSource) scanSourceDir() (numFiles int, err error) {
 			continue
 		}
 
+		name := fname[:len(fname)-len(sfx)]
+		if event, ok := callbackEvent(name); ok {
+			if src.callbacks == nil {
+				src.callbacks = map[fwish.CallbackEvent][]fwish.MigrationInfo{}
+			}
+			src.callbacks[event] = append(src.callbacks[event], fwish.MigrationInfo{
+				Name:     name,
+				Script:   fname,
+				Checksum: cksum,
+			})
+			continue
+		}
+
+		directives, err := src.readDirectives(fname)
+		if err != nil {
+			return 0, err
+		}
+
 		src.migrations = append(src.migrations, fwish.MigrationInfo{
-			Name:     fname[:len(fname)-len(sfx)],
-			Script:   fname,
-			Checksum: cksum,
+			Name:          name,
+			Script:        fname,
+			Checksum:      cksum,
+			NoTransaction: directives.noTransaction,
+			Timeout:       directives.timeout,
 		})
 	}
 
@@ -147,16 +336,5 @@ func (src *sqlFSSource) checksumSourceFile(filename string) (uint32, error) {
 	}
 	defer fh.Close()
 
-	scanner := bufio.NewScanner(fh)
-	scanner.Split(bufio.ScanLines)
-
-	ck := crc32.NewIEEE()
-	for scanner.Scan() {
-		_, err = ck.Write(scanner.Bytes())
-		if err != nil {
-			return 0, err
-		}
-	}
-
-	return ck.Sum32(), nil
+	return Checksum(fh)
 }
@@ -0,0 +1,207 @@
+package sql_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rez-go/fwish"
+	sqlsource "github.com/rez-go/fwish/sources/sql"
+)
+
+// writeTestSource writes the files of a source into a temporary
+// directory and returns the directory. A fwish.yaml is written unless
+// it's in the files.
+func writeTestSource(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if _, ok := files["fwish.yaml"]; !ok {
+		writeTestFile(t, dir, "fwish.yaml", "id: 372ce18d-02a2-4cb1-828a-bb470f02fe6e\n")
+	}
+	for name, content := range files {
+		writeTestFile(t, dir, name, content)
+	}
+	return dir
+}
+
+func writeTestFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadTestMigrations loads the source in dir and returns its migrations
+// keyed by their names.
+func loadTestMigrations(t *testing.T, dir string) (fwish.MigrationSource, map[string]fwish.MigrationInfo) {
+	t.Helper()
+	src, err := sqlsource.LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := src.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos := map[string]fwish.MigrationInfo{}
+	for _, mi := range migrations {
+		infos[mi.Name] = mi
+	}
+	return src, infos
+}
+
+func TestDirectives(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql": "CREATE TABLE person (id int, name text);\n",
+		"V2__Index.sql": "-- Index without locking the table\n" +
+			"-- fwish:no-transaction\n" +
+			"-- fwish:timeout=10m\n" +
+			"CREATE INDEX CONCURRENTLY person_name_idx ON person (name);\n" +
+			"-- fwish:timeout=1s\n",
+		"V3__Enum.sql":      "ALTER TYPE mood ADD VALUE 'meh';\n",
+		"V3__Enum.sql.conf": "# Flyway's script config\nexecuteInTransaction=false\n",
+		// Saved with a byte order mark and CR line breaks
+		"V4__Bom.sql": "\uFEFF-- fwish:no-transaction\r-- fwish:timeout=1m\rVACUUM person;\r",
+	})
+
+	_, infos := loadTestMigrations(t, dir)
+	if len(infos) != 4 {
+		t.Fatalf("4 migrations expected, got %v", infos)
+	}
+	if mi := infos["V1__Init"]; mi.NoTransaction || mi.Timeout != 0 {
+		t.Fatalf("V1__Init should have no directives, got %+v", mi)
+	}
+	if mi := infos["V2__Index"]; !mi.NoTransaction || mi.Timeout != 10*time.Minute {
+		t.Fatalf("V2__Index directives were not loaded, got %+v", mi)
+	}
+	if mi := infos["V3__Enum"]; !mi.NoTransaction {
+		t.Fatalf("V3__Enum config was not loaded, got %+v", mi)
+	}
+	if mi := infos["V4__Bom"]; !mi.NoTransaction || mi.Timeout != time.Minute {
+		t.Fatalf("V4__Bom directives were not loaded, got %+v", mi)
+	}
+
+	writeTestFile(t, dir, "V5__Typo.sql", "-- fwish:no-transactoin\nSELECT 1;\n")
+	src, err := sqlsource.LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = src.Migrations()
+	if err == nil || !strings.Contains(err.Error(), "unknown directive") {
+		t.Fatalf("unknown directive error expected, got %v", err)
+	}
+}
+
+func TestEmptyScripts(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql":  "CREATE TABLE person (id int, name text);\n",
+		"V2__Empty.sql": "",
+		"R__Blank.sql":  "\n\r\n",
+	})
+
+	_, infos := loadTestMigrations(t, dir)
+	if _, ok := infos["V1__Init"]; !ok || len(infos) != 1 {
+		t.Fatalf("only V1__Init expected, got %v", infos)
+	}
+}
+
+func TestCallbackScripts(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql":                    "CREATE TABLE person (id int, name text);\n",
+		"beforeMigrate.sql":               "SELECT 1;\n",
+		"afterMigrate__Refresh_views.sql": "SELECT 2;\n",
+		"afterEachMigrateError.sql":       "SELECT 3;\n",
+	})
+
+	src, infos := loadTestMigrations(t, dir)
+	if _, ok := infos["V1__Init"]; !ok || len(infos) != 1 {
+		t.Fatalf("only V1__Init expected, got %v", infos)
+	}
+	if _, ok := src.(fwish.CallbackSource); !ok {
+		t.Fatal("the source should provide callbacks")
+	}
+
+	mg, err := fwish.NewMigrator("372ce18d-02a2-4cb1-828a-bb470f02fe6e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mg.AddSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCallbackScript(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"V1__Init.sql":            "CREATE TABLE person (id int, name text);\n",
+		"afterMigrate.sql":        "ANALYZE person;\n",
+		"afterMigrate__Grant.sql": "GRANT SELECT ON person TO ${reader}\n",
+	})
+
+	src, _ := loadTestMigrations(t, dir)
+	cs, ok := src.(fwish.CallbackScriptSource)
+	if !ok {
+		t.Fatal("the source should implement CallbackScriptSource")
+	}
+
+	script, err := cs.CallbackScript(context.Background(), fwish.CallbackInfo{
+		Event:        fwish.CallbackAfterMigrate,
+		Placeholders: map[string]string{"reader": "app"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "ANALYZE person;\nGRANT SELECT ON person TO app;\n"
+	if script != expected {
+		t.Fatalf("expected %q, got %q", expected, script)
+	}
+
+	script, err = cs.CallbackScript(context.Background(), fwish.CallbackInfo{
+		Event: fwish.CallbackBeforeMigrate,
+	})
+	if err != nil || script != "" {
+		t.Fatalf("no script expected, got %q, %v", script, err)
+	}
+}
+
+func TestMigrationScript(t *testing.T) {
+	dir := writeTestSource(t, map[string]string{
+		"fwish.yaml": "id: 372ce18d-02a2-4cb1-828a-bb470f02fe6e\n" +
+			"placeholders:\n  owner: nobody\n",
+		"V1__Init.sql": "-- The people\n" +
+			"CREATE TABLE person (id int, owner text);\r\n" +
+			"COPY person (id, owner) FROM stdin;\n1\t${owner}\n\\.\n" +
+			"SELECT 1",
+		"V2__Unknown.sql": "SELECT '${nope}';\n",
+	})
+
+	src, infos := loadTestMigrations(t, dir)
+	ss, ok := src.(fwish.ScriptSource)
+	if !ok {
+		t.Fatal("the source should implement ScriptSource")
+	}
+
+	mi := infos["V1__Init"]
+	mi.Placeholders = map[string]string{"owner": "Alice"}
+	script, err := ss.MigrationScript(context.Background(), mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "CREATE TABLE person (id int, owner text);\n" +
+		"COPY person (id, owner) FROM stdin;\n1\tAlice\n\\.\n" +
+		"SELECT 1;\n"
+	if script != expected {
+		t.Fatalf("expected %q, got %q", expected, script)
+	}
+
+	_, err = ss.MigrationScript(context.Background(), infos["V2__Unknown"])
+	var me *fwish.MigrationError
+	if !errors.As(err, &me) || me.Line != 1 || !strings.Contains(me.Error(), "${nope}") {
+		t.Fatalf("MigrationError expected, got %v", err)
+	}
+}
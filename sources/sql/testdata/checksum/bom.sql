@@ -0,0 +1,2 @@
+﻿CREATE TABLE person (id int, name text);
+INSERT INTO person VALUES (1, 'Alice');
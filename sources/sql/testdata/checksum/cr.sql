@@ -0,0 +1 @@
+CREATE TABLE person (id int, name text);INSERT INTO person VALUES (1, 'Alice');
\ No newline at end of file
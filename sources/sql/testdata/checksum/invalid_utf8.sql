@@ -0,0 +1,4 @@
+SELECT 'ab';
+SELECT 'cd';
+SELECT '';
+SELECT '';
@@ -0,0 +1 @@
+INSERT INTO person VALUES (2, 'Élodie'), (3, '日本語'), (4, '🙂');
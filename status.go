@@ -0,0 +1,296 @@
+package fwish
+
+import (
+	"context"
+	"time"
+
+	"github.com/rez-go/fwish/version"
+)
+
+// MigrationState describes the state of a migration as reported by
+// Status.
+type MigrationState string
+
+const (
+	// MigrationStatePending is for migrations which are available in the
+	// sources but have not been applied yet.
+	MigrationStatePending MigrationState = "pending"
+	// MigrationStateApplied is for migrations which have been applied
+	// successfully and match the sources.
+	MigrationStateApplied MigrationState = "applied"
+	// MigrationStateFailed is for migrations which have been recorded as
+	// failed in the metadata table.
+	MigrationStateFailed MigrationState = "failed"
+	// MigrationStateMissing is for migrations which have been applied
+	// but are not available in the sources.
+	MigrationStateMissing MigrationState = "missing-from-source"
+	// MigrationStateChecksumMismatch is for migrations which have been
+	// applied but have been modified in the sources afterward.
+	MigrationStateChecksumMismatch MigrationState = "checksum-mismatch"
+	// MigrationStateIgnored is for migrations which are available in the
+	// sources but have a version lower than the current version of the
+	// schema so that they won't be applied. They are reported as pending
+	// instead if out-of-order migrations are allowed.
+	MigrationStateIgnored MigrationState = "ignored"
+	// MigrationStateBaseline is for the row written by Baseline.
+	MigrationStateBaseline MigrationState = "baseline"
+	// MigrationStateBelowBaseline is for migrations which are available
+	// in the sources but have a version lower than or equal to the
+	// baseline version so that they won't be applied.
+	MigrationStateBelowBaseline MigrationState = "below-baseline"
+	// MigrationStateOutdated is for repeatable migrations which have
+	// been modified in the sources since they were last applied. They
+	// will be applied again.
+	MigrationStateOutdated MigrationState = "outdated"
+	// MigrationStateSuperseded is for the rows of repeatable migrations
+	// which have been applied again afterward.
+	MigrationStateSuperseded MigrationState = "superseded"
+	// MigrationStateUndone is for the rows of versioned migrations which
+	// have been reverted by their undo migrations.
+	MigrationStateUndone MigrationState = "undone"
+	// MigrationStateOutOfOrder is for versioned migrations which have
+	// been applied after a migration with higher version.
+	MigrationStateOutOfOrder MigrationState = "out-of-order"
+)
+
+// Status holds the state of a schema and its migrations.
+type Status struct {
+	// SchemaID is the ID recorded in the metadata table. It's empty if
+	// the schema has not been initialized.
+	SchemaID string
+	// SchemaName is the name of the database schema.
+	SchemaName string
+	// Initialized is true if the metadata table exists.
+	Initialized bool
+	// CurrentVersion is the highest version successfully applied to
+	// the schema.
+	CurrentVersion string
+	// Migrations lists the applied migrations ordered by their rank
+	// followed by the rest of known migrations ordered by their version.
+	Migrations []MigrationStatus
+}
+
+// MigrationStatus holds the state of a migration.
+type MigrationStatus struct {
+	// InstalledRank is the rank of the migration in the metadata table.
+	// It's 0 if the migration has not been applied.
+	InstalledRank int32
+	// Version is empty for repeatable migrations.
+	Version     string
+	Description string
+	Type        string
+	Script      string
+	// Repeatable migrations are identified by their description.
+	Repeatable bool
+	// Checksum is the checksum recorded in the metadata table, or the
+	// checksum from the source if the migration has not been applied.
+	Checksum uint32
+	// SourceChecksum is the checksum from the source. It's 0 if the
+	// migration is not available in the sources.
+	SourceChecksum uint32
+	InstalledBy    string
+	InstalledOn    time.Time
+	ExecutionTime  time.Duration
+	Success        bool
+	State          MigrationState
+}
+
+// Status returns the state of the schema and of all the known
+// migrations, i.e., the ones from the sources merged with the ones
+// recorded in the metadata table. It doesn't change anything in the DB.
+//
+// The schemaName parameter has the same semantic as Migrate's.
+func (m *Migrator) Status(db DB, schemaName string) (*Status, error) {
+	return m.StatusContext(context.Background(), contextDB(db), schemaName)
+}
+
+// StatusContext is the context-aware variant of Status.
+func (m *Migrator) StatusContext(ctx context.Context, db ContextDB, schemaName string) (*Status, error) {
+	st, err := m.newState(db, schemaName)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := readHistory(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.resolveStatus(st, rows), nil
+}
+
+// resolveStatus merges the migrations from the sources with the rows
+// loaded from the metadata table.
+func (m *Migrator) resolveStatus(st *state, rows []historyRow) *Status {
+	status := &Status{
+		SchemaName:  st.schemaName,
+		Initialized: rows != nil,
+	}
+
+	// The index in status.Migrations of the effective row, applied or
+	// failed, of each version. Versions which have been undone have no
+	// effective row.
+	effective := map[string]int{}
+	var baselineVersion version.Version
+	// The highest version in effect after the rows processed so far
+	var highestVersion version.Version
+
+	// The rank of the latest row of each repeatable migration
+	latestRepeatable := map[string]int32{}
+	for _, row := range rows {
+		if row.installedRank != 0 && !row.version.Valid && row.typ == MigrationTypeSQL {
+			latestRepeatable[row.description] = row.installedRank
+		}
+	}
+
+	for _, row := range rows {
+		if row.installedRank == 0 {
+			status.SchemaID = row.script
+			continue
+		}
+
+		ms := MigrationStatus{
+			InstalledRank: row.installedRank,
+			Description:   row.description,
+			Type:          row.typ,
+			Script:        row.script,
+			Checksum:      uint32(row.checksum.Int32),
+			InstalledBy:   row.installedBy,
+			InstalledOn:   row.installedOn,
+			ExecutionTime: time.Duration(row.executionTime) * time.Millisecond,
+			Success:       row.success,
+		}
+
+		var vints version.Version
+		if row.version.Valid {
+			ms.Version = row.version.String
+			if v, err := version.Parse(row.version.String); err == nil {
+				vints = v
+				ms.Version = v.String()
+			}
+		}
+
+		var mig migration
+		var inSource bool
+		switch {
+		case row.typ == MigrationTypeUndoSQL:
+			mig, inSource = m.undos[ms.Version]
+		case row.typ == MigrationTypeSchema:
+			// Not a migration
+		case row.version.Valid:
+			mig, inSource = m.migrations[ms.Version]
+		default:
+			ms.Repeatable = true
+			mig, inSource = m.repeatables[row.description]
+		}
+		if inSource {
+			ms.SourceChecksum = mig.checksum
+		}
+
+		switch {
+		case row.typ == MigrationTypeUndoSQL && !row.success:
+			ms.State = MigrationStateFailed
+		case row.typ == MigrationTypeUndoSQL:
+			ms.State = MigrationStateApplied
+			if idx, ok := effective[ms.Version]; ok {
+				status.Migrations[idx].State = MigrationStateUndone
+				delete(effective, ms.Version)
+				// A version applied again after the undo isn't out
+				// of order.
+				highestVersion = highestEffectiveVersion(status.Migrations, effective)
+			}
+		case row.typ == MigrationTypeBaseline:
+			ms.State = MigrationStateBaseline
+			baselineVersion = vints
+		case row.typ == MigrationTypeSchema:
+			ms.State = MigrationStateApplied
+		case ms.Repeatable && latestRepeatable[row.description] != row.installedRank:
+			ms.State = MigrationStateSuperseded
+		case !row.success:
+			ms.State = MigrationStateFailed
+		case !inSource:
+			ms.State = MigrationStateMissing
+		case mig.checksum != ms.Checksum && mig.repeatable:
+			ms.State = MigrationStateOutdated
+		case mig.checksum != ms.Checksum:
+			ms.State = MigrationStateChecksumMismatch
+		case vints != nil && version.Compare(vints, highestVersion) < 0:
+			ms.State = MigrationStateOutOfOrder
+		default:
+			ms.State = MigrationStateApplied
+		}
+
+		if row.success && row.typ != MigrationTypeUndoSQL &&
+			version.Compare(vints, highestVersion) > 0 {
+			highestVersion = vints
+		}
+
+		if vints != nil && row.typ != MigrationTypeUndoSQL {
+			effective[ms.Version] = len(status.Migrations)
+		}
+
+		status.Migrations = append(status.Migrations, ms)
+	}
+
+	currentVersion := highestEffectiveVersion(status.Migrations, effective)
+	status.CurrentVersion = currentVersion.String()
+
+	for _, vstr := range m.versions {
+		if _, ok := effective[vstr]; ok {
+			continue
+		}
+		mig := m.migrations[vstr]
+		ms := MigrationStatus{
+			Version:        mig.versionStr,
+			Description:    mig.label,
+			Type:           MigrationTypeSQL,
+			Script:         mig.script,
+			Checksum:       mig.checksum,
+			SourceChecksum: mig.checksum,
+			State:          MigrationStatePending,
+		}
+		switch {
+		case baselineVersion != nil &&
+			version.Compare(mig.versionInts, baselineVersion) <= 0:
+			ms.State = MigrationStateBelowBaseline
+		case version.Compare(mig.versionInts, currentVersion) < 0 && !m.outOfOrder:
+			ms.State = MigrationStateIgnored
+		}
+		status.Migrations = append(status.Migrations, ms)
+	}
+
+	for _, label := range m.repeatableLabels {
+		if _, ok := latestRepeatable[label]; ok {
+			continue
+		}
+		mig := m.repeatables[label]
+		status.Migrations = append(status.Migrations, MigrationStatus{
+			Description:    mig.label,
+			Type:           MigrationTypeSQL,
+			Script:         mig.script,
+			Repeatable:     true,
+			Checksum:       mig.checksum,
+			SourceChecksum: mig.checksum,
+			State:          MigrationStatePending,
+		})
+	}
+
+	return status
+}
+
+// highestEffectiveVersion returns the highest version among the
+// effective rows which were successful.
+func highestEffectiveVersion(migrations []MigrationStatus, effective map[string]int) version.Version {
+	var highest version.Version
+	for _, idx := range effective {
+		ms := migrations[idx]
+		if !ms.Success {
+			continue
+		}
+		if v, err := version.Parse(ms.Version); err == nil &&
+			version.Compare(v, highest) > 0 {
+			highest = v
+		}
+	}
+	return highest
+}
@@ -0,0 +1,137 @@
+package fwish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/rez-go/fwish/version"
+)
+
+// ErrUndoBaseline is returned by Undo when the target is lower than
+// the baseline version of the schema.
+var ErrUndoBaseline = errors.New("fwish: unable to undo beyond the baseline")
+
+// Undo reverts the applied versioned migrations by executing their undo
+// migrations, i.e., the ones with U prefix, in the reverse order they
+// were applied. Each undo is recorded in the metadata table as an
+// UNDO_SQL row, like Flyway Teams does, so that the reverted versions
+// become pending again.
+//
+// All the applied migrations with version higher than target will be
+// reverted. If target is empty, only the latest applied migration will
+// be reverted. Nothing is executed if any of those migrations has no
+// undo migration.
+//
+// The schemaName parameter has the same semantic as Migrate's.
+func (m *Migrator) Undo(db DB, schemaName string, target string) (num int, err error) {
+	return m.UndoContext(context.Background(), contextDB(db), schemaName, target)
+}
+
+// UndoContext is the context-aware variant of Undo.
+func (m *Migrator) UndoContext(
+	ctx context.Context, db ContextDB, schemaName string, target string,
+) (num int, err error) {
+	var targetVersion version.Version
+	if target != "" {
+		targetVersion, err = version.Parse(target)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	st, err := m.newState(db, schemaName)
+	if err != nil {
+		return -1, err
+	}
+
+	releaseConn, err := m.pinConn(ctx, st)
+	if err != nil {
+		return -1, err
+	}
+	defer releaseConn()
+
+	releaseLock, err := m.acquireLock(ctx, st)
+	if err != nil {
+		return -1, err
+	}
+	defer releaseLock()
+
+	restoreSearchPath, err := m.useSchema(ctx, st)
+	if err != nil {
+		return -1, err
+	}
+	defer restoreSearchPath()
+
+	status, err := m.validateDBSchema(ctx, st)
+	if err != nil {
+		return -1, err
+	}
+
+	undos, err := m.undoMigrations(status, targetVersion)
+	if err != nil {
+		return -1, err
+	}
+
+	for _, sf := range undos {
+		m.logf("Undoing migration of schema %q version %s - %s",
+			st.schemaName, sf.versionStr, sf.label)
+		err = m.executeMigration(ctx, st, st.installedRank+1, &sf)
+		if err != nil {
+			return -1, err
+		}
+		st.installedRank++
+		num++
+	}
+
+	return num, nil
+}
+
+// undoMigrations returns the undo migrations to revert the applied
+// migrations with version higher than target, or the latest applied
+// migration if target is nil, in the order they should be executed.
+func (m *Migrator) undoMigrations(status *Status, target version.Version) ([]migration, error) {
+	var applied []MigrationStatus
+	for _, ms := range status.Migrations {
+		if ms.InstalledRank == 0 || ms.Repeatable || ms.Type == MigrationTypeSchema {
+			continue
+		}
+		switch ms.State {
+		case MigrationStateApplied, MigrationStateOutOfOrder, MigrationStateBaseline:
+			if ms.Type == MigrationTypeUndoSQL {
+				continue
+			}
+			applied = append(applied, ms)
+		}
+	}
+	// Latest first
+	sort.SliceStable(applied, func(i, j int) bool {
+		return applied[i].InstalledRank > applied[j].InstalledRank
+	})
+
+	if target == nil && len(applied) > 0 {
+		applied = applied[:1]
+	}
+
+	var undos []migration
+	for _, ms := range applied {
+		v, err := version.Parse(ms.Version)
+		if err != nil {
+			return nil, err
+		}
+		if target != nil && version.Compare(v, target) <= 0 {
+			continue
+		}
+		if ms.State == MigrationStateBaseline {
+			return nil, ErrUndoBaseline
+		}
+		sf, ok := m.undos[ms.Version]
+		if !ok {
+			return nil, fmt.Errorf("fwish: no undo migration for version %s", ms.Version)
+		}
+		undos = append(undos, sf)
+	}
+
+	return undos, nil
+}
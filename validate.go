@@ -0,0 +1,223 @@
+package fwish
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rez-go/fwish/version"
+)
+
+// ValidationProblemKind classifies the problems found by Validate.
+type ValidationProblemKind string
+
+const (
+	// ValidationProblemSchemaIDMismatch is reported when the schema ID
+	// recorded in the metadata table doesn't match the migrator's.
+	ValidationProblemSchemaIDMismatch ValidationProblemKind = "schema-id-mismatch"
+	// ValidationProblemInsequentialRank is reported when the installed
+	// ranks in the metadata table are not sequential.
+	ValidationProblemInsequentialRank ValidationProblemKind = "insequential-rank"
+	// ValidationProblemFailedMigration is reported for every migration
+	// recorded as failed in the metadata table.
+	ValidationProblemFailedMigration ValidationProblemKind = "failed-migration"
+	// ValidationProblemMissingMigration is reported for every applied
+	// migration which is not available in the sources.
+	ValidationProblemMissingMigration ValidationProblemKind = "missing-migration"
+	// ValidationProblemUnknownMigration is reported for every applied
+	// migration which is not available in the sources and has a version
+	// higher than all the migrations in the sources.
+	ValidationProblemUnknownMigration ValidationProblemKind = "unknown-migration"
+	// ValidationProblemChecksumMismatch is reported for every applied
+	// migration which has been modified in the sources afterward.
+	ValidationProblemChecksumMismatch ValidationProblemKind = "checksum-mismatch"
+	// ValidationProblemIgnoredMigration is reported for every migration
+	// in the sources which has not been applied but has a version lower
+	// than the current version of the schema.
+	ValidationProblemIgnoredMigration ValidationProblemKind = "ignored-migration"
+)
+
+// ValidationProblem describes a discrepancy between the migration
+// sources and the metadata table.
+type ValidationProblem struct {
+	Kind ValidationProblemKind
+	// InstalledRank is the rank of the row in the metadata table the
+	// problem is about. It's 0 if the problem is not about a row.
+	InstalledRank int32
+	Version       string
+	Script        string
+	Message       string
+	// Err is the typed error of the problem, e.g.,
+	// *ChecksumMismatchError, or a sentinel error like
+	// ErrSchemaIDMismatch.
+	Err error
+}
+
+// Error implements the error interface so that a problem could be
+// returned as-is.
+func (p *ValidationProblem) Error() string {
+	return "fwish: " + p.Message
+}
+
+// Unwrap returns the typed error of the problem so that it could be
+// checked with errors.As and errors.Is.
+func (p *ValidationProblem) Unwrap() error {
+	return p.Err
+}
+
+// Validate checks the migrations from the sources against the ones
+// recorded in the metadata table without applying anything. Unlike
+// Migrate, which stops at the first problem, it returns all the
+// problems it found. The returned error is for other kind of errors,
+// e.g., DB errors.
+//
+// Pending migrations are not considered as problems. A schema which
+// has not been initialized has no problems.
+//
+// The schemaName parameter has the same semantic as Migrate's.
+func (m *Migrator) Validate(db DB, schemaName string) ([]ValidationProblem, error) {
+	return m.ValidateContext(context.Background(), contextDB(db), schemaName)
+}
+
+// ValidateContext is the context-aware variant of Validate.
+func (m *Migrator) ValidateContext(
+	ctx context.Context, db ContextDB, schemaName string,
+) ([]ValidationProblem, error) {
+	st, err := m.newState(db, schemaName)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := readHistory(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.validateHistory(rows, m.resolveStatus(st, rows)), nil
+}
+
+func (m *Migrator) validateHistory(rows []historyRow, status *Status) []ValidationProblem {
+	var problems []ValidationProblem
+
+	// The ranks start at 0 with the meta row, or at 1, like Flyway's,
+	// if there's none.
+	firstRank := int32(1)
+	if len(rows) > 0 && rows[0].installedRank == 0 {
+		firstRank = 0
+	}
+	for i, row := range rows {
+		expectedRank := firstRank + int32(i)
+		if m.flywayMode && i > 0 {
+			// Flyway's tables have gaps, e.g., where flyway repair
+			// deleted the failed rows, so the ranks only need to
+			// increase.
+			expectedRank = rows[i-1].installedRank + 1
+			if row.installedRank > expectedRank {
+				continue
+			}
+		}
+		if row.installedRank != expectedRank {
+			// class: schema consistency
+			problems = append(problems, ValidationProblem{
+				Kind:          ValidationProblemInsequentialRank,
+				InstalledRank: row.installedRank,
+				Message: fmt.Sprintf(
+					"insequential installed_rank %d, expecting %d",
+					row.installedRank, expectedRank),
+			})
+			break
+		}
+	}
+
+	hasMeta := firstRank == 0
+	if hasMeta && m.schemaID != "" && status.SchemaID != m.schemaID {
+		problems = append(problems, ValidationProblem{
+			Kind: ValidationProblemSchemaIDMismatch,
+			Message: fmt.Sprintf("schema ID mismatch: %q recorded, %q expected",
+				status.SchemaID, m.schemaID),
+			Err: ErrSchemaIDMismatch,
+		})
+	}
+
+	latestVersion := m.latestSourceVersion()
+
+	for _, ms := range status.Migrations {
+		p := ValidationProblem{
+			InstalledRank: ms.InstalledRank,
+			Version:       ms.Version,
+			Script:        ms.Script,
+		}
+		switch ms.State {
+		case MigrationStateFailed:
+			p.Kind = ValidationProblemFailedMigration
+			p.Err = &FailedMigrationError{
+				InstalledRank: ms.InstalledRank,
+				Version:       ms.Version,
+				Description:   ms.Description,
+				Script:        ms.Script,
+			}
+		case MigrationStateMissing:
+			if !ms.Repeatable && isNewerThan(ms.Version, latestVersion) {
+				p.Kind = ValidationProblemUnknownMigration
+				p.Err = &UnknownAppliedMigrationError{
+					InstalledRank: ms.InstalledRank,
+					Version:       ms.Version,
+					Script:        ms.Script,
+				}
+			} else {
+				p.Kind = ValidationProblemMissingMigration
+				p.Err = &MissingMigrationError{
+					InstalledRank: ms.InstalledRank,
+					Version:       ms.Version,
+					Description:   ms.Description,
+					Script:        ms.Script,
+				}
+			}
+		case MigrationStateChecksumMismatch:
+			p.Kind = ValidationProblemChecksumMismatch
+			p.Err = &ChecksumMismatchError{
+				InstalledRank: ms.InstalledRank,
+				Version:       ms.Version,
+				Script:        ms.Script,
+				Expected:      ms.SourceChecksum,
+				Actual:        ms.Checksum,
+			}
+		case MigrationStateIgnored:
+			p.Kind = ValidationProblemIgnoredMigration
+			p.Err = &IgnoredMigrationError{
+				Version:        ms.Version,
+				Script:         ms.Script,
+				CurrentVersion: status.CurrentVersion,
+			}
+		default:
+			continue
+		}
+		p.Message = strings.TrimPrefix(p.Err.Error(), "fwish: ")
+		problems = append(problems, p)
+	}
+
+	return problems
+}
+
+// latestSourceVersion returns the highest version of the versioned
+// migrations in the sources, or nil if there's none.
+func (m *Migrator) latestSourceVersion() version.Version {
+	if len(m.versions) == 0 {
+		return nil
+	}
+	v, _ := version.Parse(m.versions[len(m.versions)-1])
+	return v
+}
+
+// isNewerThan returns true if versionStr is higher than latest. It
+// returns false if any of them is not available.
+func isNewerThan(versionStr string, latest version.Version) bool {
+	if latest == nil {
+		return false
+	}
+	v, err := version.Parse(versionStr)
+	if err != nil || v == nil {
+		return false
+	}
+	return version.Compare(v, latest) > 0
+}
@@ -51,6 +51,30 @@ func Parse(vstr string) (Version, error) {
 	return Version(ints), nil
 }
 
+// Compare returns -1 if a is lower than b, 1 if a is higher than b and
+// 0 if both are equal.
+func Compare(a, b Version) int {
+	mx := len(a)
+	if len(b) < mx {
+		mx = len(b)
+	}
+	for k := 0; k < mx; k++ {
+		if a[k] < b[k] {
+			return -1
+		}
+		if a[k] > b[k] {
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
 // SortStrings sort a list of version string.
 func SortStrings(versions []string) error {
 	if len(versions) == 0 {
@@ -69,23 +93,7 @@ func SortStrings(versions []string) error {
 		items[i] = item{v, s}
 	}
 	sort.SliceStable(items, func(i, j int) bool {
-		vlA := items[i].v
-		vlB := items[j].v
-		var mx int
-		if len(vlA) < len(vlB) {
-			mx = len(vlA)
-		} else {
-			mx = len(vlB)
-		}
-		for k := 0; k < mx; k++ {
-			if vlA[k] < vlB[k] {
-				return true
-			}
-			if vlA[k] > vlB[k] {
-				return false
-			}
-		}
-		return len(vlA) < len(vlB)
+		return Compare(items[i].v, items[j].v) < 0
 	})
 	for i, it := range items {
 		versions[i] = it.orig
@@ -87,6 +87,37 @@ func TestSort(t *testing.T) {
 	}
 }
 
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		a, b   string
+		result int
+	}{
+		{"", "", 0},
+		{"1", "1", 0},
+		{"1", "2", -1},
+		{"2", "1", 1},
+		{"1", "1.0", -1},
+		{"1.0", "1", 1},
+		{"3.1", "3.10", -1},
+		{"002.0002", "2.2", 0},
+		{"10", "4_2", 1},
+	}
+
+	for i, c := range cases {
+		a, err := version.Parse(c.a)
+		if err != nil {
+			t.Fatalf("#%d: %v", i+1, err)
+		}
+		b, err := version.Parse(c.b)
+		if err != nil {
+			t.Fatalf("#%d: %v", i+1, err)
+		}
+		if r := version.Compare(a, b); r != c.result {
+			t.Errorf("#%d: expected %d, got %d", i+1, c.result, r)
+		}
+	}
+}
+
 func intsEq(a, b []int64) bool {
 	if a == nil && b == nil {
 		return true